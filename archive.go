@@ -0,0 +1,377 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/google/go-github/v57/github"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// 解压后的总大小上限，防止异常归档耗尽内存
+const archiveMaxBytes = 64 << 20
+
+// 下载的归档（压缩后）大小上限
+const archiveMaxDownloadBytes = 32 << 20
+
+// 按提交 SHA 缓存解压后的配置文件，提交不可变，因此无需过期，按数量和总字节数做 LRU 淘汰
+type archiveCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	size    int
+}
+
+type archiveEntry struct {
+	key   string
+	files map[string][]byte
+	size  int
+}
+
+var archives = &archiveCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+func (c *archiveCache) get(key string) (map[string][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*archiveEntry).files, true
+}
+
+func (c *archiveCache) add(key string, files map[string][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	entry := &archiveEntry{key: key, files: files}
+	for _, content := range files {
+		entry.size += len(content)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += entry.size
+
+	// 至少保留刚加入的一项
+	maxBytes := ArchiveCacheMaxMB << 20
+	for c.order.Len() > max(ArchiveCacheSize, 1) || (maxBytes > 0 && c.size > maxBytes && c.order.Len() > 1) {
+		oldest := c.order.Remove(c.order.Back()).(*archiveEntry)
+		delete(c.entries, oldest.key)
+		c.size -= oldest.size
+	}
+}
+
+// 支持归档下载的服务器类型
+func supportsArchive() bool {
+	switch strings.ToLower(ServerType) {
+	case "gitea", "forgejo", "github", "gitlab":
+		return true
+	}
+	return false
+}
+
+// 通过归档获取目录下所有文件：解析 ref 得到提交 SHA，命中缓存则不再下载
+func fetchFilesFromArchive(ctx context.Context, namespace, repo, branch, dir string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "archive.fetch_files", resolvedAttributes(namespace, repo, branch, dir)...)
+	defer func() { endSpan(span, err) }()
+
+	format := strings.ToLower(ArchiveFormat)
+	if format != "tar.gz" && format != "zip" {
+		return nil, fmt.Errorf("unsupported ARCHIVE_FORMAT %q", ArchiveFormat)
+	}
+
+	sha, err := resolveCommit(ctx, namespace, repo, branch)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to resolve ref", "ref", branch, "error", err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("vcs.commit", sha))
+
+	key := strings.Join([]string{strings.ToLower(ServerType), ServerURL, namespace, repo, sha, format}, "\x00")
+	files, ok := archives.get(key)
+	if ok {
+		slog.DebugContext(ctx, "archive cache hit", "commit", sha)
+	} else {
+		dlCtx, dlSpan := startSpan(ctx, "archive.download", attribute.String("vcs.commit", sha))
+		var data []byte
+		data, err = downloadArchive(dlCtx, namespace, repo, sha, format)
+		if err == nil {
+			files, err = extractArchive(data, format)
+		}
+		endSpan(dlSpan, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to download archive", "commit", sha, "error", err)
+			return nil, err
+		}
+		slog.DebugContext(ctx, "downloaded archive", "commit", sha, "bytes", len(data), "files", len(files))
+		archives.add(key, files)
+	}
+
+	return archiveDir(files, dir)
+}
+
+// 取出归档中指定目录下的配置文件（不递归）
+func archiveDir(files map[string][]byte, dir string) ([]GiteaFile, error) {
+	dir = strings.Trim(dir, "/")
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+
+	found := false
+	var result []GiteaFile
+	for name, content := range files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		found = true
+		rel := strings.TrimPrefix(name, prefix)
+		if strings.Contains(rel, "/") || !isConfigFile(rel) {
+			continue
+		}
+		result = append(result, GiteaFile{Name: rel, Path: name, Type: "file", Content: string(content)})
+	}
+	if !found {
+		return nil, notFoundError(fmt.Errorf("path %q not found in archive", dir))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// 解压归档到内存，去掉顶层目录（owner-repo-sha/ 等）。
+// 只保留配置文件的内容，其他文件只记录所在目录（"dir/" 键），用于判断目录是否存在
+func extractArchive(data []byte, format string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	total := 0
+	addFile := func(name string, size int64, r io.Reader) error {
+		_, rel, ok := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+		if !ok || rel == "" {
+			return nil
+		}
+		if !isConfigFile(path.Base(rel)) {
+			if total += int(size); total > archiveMaxBytes {
+				return fmt.Errorf("archive exceeds %d bytes", archiveMaxBytes)
+			}
+			if dir := path.Dir(rel); dir != "." {
+				files[dir+"/"] = nil
+			}
+			return nil
+		}
+		content, err := io.ReadAll(io.LimitReader(r, archiveMaxBytes-int64(total)+1))
+		if err != nil {
+			return err
+		}
+		if total += len(content); total > archiveMaxBytes {
+			return fmt.Errorf("archive exceeds %d bytes", archiveMaxBytes)
+		}
+		files[rel] = content
+		return nil
+	}
+
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("open tar.gz: %w", err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read tar.gz: %w", err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := addFile(hdr.Name, hdr.Size, tr); err != nil {
+				return nil, err
+			}
+		}
+	case "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("open zip: %w", err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("read zip: %w", err)
+			}
+			err = addFile(f.Name, int64(f.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	// 归档路径统一为 / 分隔且不含 ..
+	for name := range files {
+		if clean := strings.TrimSuffix(name, "/"); path.Clean(clean) != clean || strings.HasPrefix(clean, "../") {
+			delete(files, name)
+		}
+	}
+	return files, nil
+}
+
+// 把分支、tag 或 SHA 解析为提交 SHA
+func resolveCommit(ctx context.Context, namespace, repo, ref string) (string, error) {
+	switch strings.ToLower(ServerType) {
+	case "gitea", "forgejo":
+		client, err := newGiteaCompatibleClient(ctx)
+		if err != nil {
+			return "", err
+		}
+		commits, _, err := client.ListRepoCommits(namespace, repo, gitea.ListCommitOptions{
+			ListOptions: gitea.ListOptions{PageSize: 1},
+			SHA:         ref,
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(commits) == 0 {
+			return "", fmt.Errorf("ref %q has no commits", ref)
+		}
+		return commits[0].SHA, nil
+	case "github":
+		client, err := newGitHubClient(ctx, namespace)
+		if err != nil {
+			return "", err
+		}
+		sha, _, err := client.Repositories.GetCommitSHA1(ctx, namespace, repo, ref, "")
+		return sha, err
+	case "gitlab":
+		client, err := newGitLabClient(ctx)
+		if err != nil {
+			return "", err
+		}
+		commit, _, err := client.Commits.GetCommit(gitlabProjectID(namespace, repo), ref, nil, gitlab.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		return commit.ID, nil
+	default:
+		return "", fmt.Errorf("archive mode is not supported for server type %s", ServerType)
+	}
+}
+
+// 下载指定提交的仓库归档
+func downloadArchive(ctx context.Context, namespace, repo, sha, format string) ([]byte, error) {
+	switch strings.ToLower(ServerType) {
+	case "gitea", "forgejo":
+		client, err := newGiteaCompatibleClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ext := gitea.TarGZArchive
+		if format == "zip" {
+			ext = gitea.ZipArchive
+		}
+		rc, _, err := client.GetArchiveReader(namespace, repo, sha, ext)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return readArchive(rc)
+	case "github":
+		client, err := newGitHubClient(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		archiveFormat := github.Tarball
+		if format == "zip" {
+			archiveFormat = github.Zipball
+		}
+		// 返回的是带临时令牌的下载地址，不需要再附带认证头
+		link, _, err := client.Repositories.GetArchiveLink(ctx, namespace, repo, archiveFormat,
+			&github.RepositoryContentGetOptions{Ref: sha}, 3)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := newHTTPClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+		}
+		return readArchive(resp.Body)
+	case "gitlab":
+		client, err := newGitLabClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		buf := &archiveBuffer{}
+		_, err = client.Repositories.StreamArchive(gitlabProjectID(namespace, repo), buf, &gitlab.ArchiveOptions{
+			Format: gitlab.Ptr(format),
+			SHA:    gitlab.Ptr(sha),
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("archive mode is not supported for server type %s", ServerType)
+	}
+}
+
+// 读取下载的归档，超过 archiveMaxDownloadBytes 时报错
+func readArchive(r io.Reader) ([]byte, error) {
+	buf := &archiveBuffer{}
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 写入超过 archiveMaxDownloadBytes 时返回错误，中止下载
+type archiveBuffer struct {
+	bytes.Buffer
+}
+
+func (b *archiveBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > archiveMaxDownloadBytes {
+		return 0, fmt.Errorf("archive download exceeds %d bytes", archiveMaxDownloadBytes)
+	}
+	return b.Buffer.Write(p)
+}
+
+// gitea 与 forgejo 都使用 Gitea SDK
+func newGiteaCompatibleClient(ctx context.Context) (*gitea.Client, error) {
+	if strings.EqualFold(ServerType, "forgejo") {
+		return newForgejoClient(ctx)
+	}
+	return newGiteaClient(ctx)
+}
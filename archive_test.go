@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func buildTarGz(t *testing.T, prefix string, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: prefix, Typeflag: tar.TypeDir, Mode: 0o755})
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: prefix + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, prefix string, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(prefix + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func useArchiveMode(t *testing.T, serverType, format string, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	previous := []string{ServerType, ServerURL, GiteaURL, FetchMode, ArchiveFormat}
+	previousCache := archives
+	ServerType, ServerURL, GiteaURL, FetchMode, ArchiveFormat = serverType, srv.URL, srv.URL, "archive", format
+	archives = &archiveCache{order: list.New(), entries: make(map[string]*list.Element)}
+	t.Cleanup(func() {
+		ServerType, ServerURL, GiteaURL, FetchMode, ArchiveFormat = previous[0], previous[1], previous[2], previous[3], previous[4]
+		archives = previousCache
+	})
+}
+
+var archiveFiles = map[string]string{
+	"app/main/build.yml":      "steps:\n  - name: build\n    image: alpine\n",
+	"app/main/test.yaml":      "steps:\n  - name: test\n    image: alpine\n",
+	"app/main/README.md":      "ignored",
+	"app/main/nested/x.yml":   "ignored: true\n",
+	"app/develop/build.yml":   "steps:\n  - name: dev\n    image: alpine\n",
+	"shared/templates/go.yml": "steps: {}\n",
+}
+
+func TestFetchFilesFromGitHubArchive(t *testing.T) {
+	const sha = "9f2c1e7a5d6b4c3a2f1e0d9c8b7a6f5e4d3c2b1a"
+	tarball := buildTarGz(t, "team-woodpeckerfiles-9f2c1e7/", archiveFiles)
+	var downloads atomic.Int32
+
+	var srvURL string
+	useArchiveMode(t, "github", "tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/team/woodpeckerfiles/commits/main":
+			fmt.Fprint(w, sha)
+		case "/api/v3/repos/team/woodpeckerfiles/tarball/" + sha:
+			http.Redirect(w, r, srvURL+"/codeload/"+sha+"?token=tmp", http.StatusFound)
+		case "/codeload/" + sha:
+			downloads.Add(1)
+			w.Write(tarball)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srvURL = ServerURL
+
+	ctx := context.Background()
+	for _, dir := range []string{"app/main", "app/develop"} {
+		if _, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", dir); err != nil {
+			t.Fatalf("fetch %s error = %v", dir, err)
+		}
+	}
+	files, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Path != "app/main/build.yml" || files[1].Name != "test.yaml" ||
+		!strings.Contains(files[1].Content, "name: test") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	// 同一提交只下载一次
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("archive downloaded %d times, want 1", n)
+	}
+
+	if _, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/missing"); err == nil {
+		t.Error("expected error for missing path")
+	}
+}
+
+func TestFetchFilesFromGiteaArchiveZip(t *testing.T) {
+	const sha = "3b18e512dba79e4c8300dd08aeb37f8e728b8dad"
+	zipball := buildZip(t, "woodpeckerfiles/", archiveFiles)
+
+	useArchiveMode(t, "gitea", "zip", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/version":
+			fmt.Fprint(w, `{"version":"1.22.0"}`)
+		case "/api/v1/repos/team/woodpeckerfiles/commits":
+			if r.URL.Query().Get("sha") != "v1.0" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `[{"sha":%q}]`, sha)
+		case "/api/v1/repos/team/woodpeckerfiles/archive/" + sha + ".zip":
+			w.Write(zipball)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	files, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "v1.0", "app/develop")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "app/develop/build.yml" || !strings.Contains(files[0].Content, "name: dev") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+}
+
+func TestArchiveCacheEviction(t *testing.T) {
+	previous := ArchiveCacheSize
+	defer func() { ArchiveCacheSize = previous }()
+	ArchiveCacheSize = 2
+
+	cache := &archiveCache{order: list.New(), entries: make(map[string]*list.Element)}
+	cache.add("a", nil)
+	cache.add("b", nil)
+	cache.get("a")
+	cache.add("c", nil)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("cache.get(%q) ok = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestArchiveCacheByteLimit(t *testing.T) {
+	previous := []int{ArchiveCacheSize, ArchiveCacheMaxMB}
+	defer func() { ArchiveCacheSize, ArchiveCacheMaxMB = previous[0], previous[1] }()
+	ArchiveCacheSize, ArchiveCacheMaxMB = 10, 1
+
+	cache := &archiveCache{order: list.New(), entries: make(map[string]*list.Element)}
+	half := make([]byte, 600<<10)
+	cache.add("a", map[string][]byte{"a.yml": half})
+	cache.add("b", map[string][]byte{"b.yml": half})
+
+	// 超过总字节数时淘汰最久未使用的提交
+	if _, ok := cache.get("a"); ok {
+		t.Error("expected a to be evicted")
+	}
+	if _, ok := cache.get("b"); !ok || cache.size != len(half) {
+		t.Errorf("b missing or size = %d", cache.size)
+	}
+}
+
+func TestExtractArchiveKeepsOnlyConfigFiles(t *testing.T) {
+	data := buildTarGz(t, "repo/", map[string]string{
+		"app/main/build.yml": "steps: {}\n",
+		"docs/README.md":     "not a pipeline",
+	})
+	files, err := extractArchive(data, "tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["app/main/build.yml"] == nil {
+		t.Errorf("unexpected files: %v", files)
+	}
+
+	// 只有其他文件的目录仍然存在，但没有配置文件
+	got, err := archiveDir(files, "docs")
+	if err != nil || len(got) != 0 {
+		t.Errorf("archiveDir(docs) = %v, %v", got, err)
+	}
+}
+
+func TestReadArchiveLimit(t *testing.T) {
+	if _, err := readArchive(bytes.NewReader(make([]byte, archiveMaxDownloadBytes+1))); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("readArchive() error = %v, want size limit error", err)
+	}
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	data := buildTarGz(t, "repo/", map[string]string{"../escape.yml": "x", "ok.yml": "y"})
+	files, err := extractArchive(data, "tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files["ok.yml"] == nil {
+		t.Errorf("unexpected files: %v", files)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Bitbucket 认证：设置 BITBUCKET_USERNAME 时用 app password（Basic），否则用 access token（Bearer）
+func bitbucketHeader(ctx context.Context) http.Header {
+	header := http.Header{}
+	token := currentToken(ctx)
+	switch {
+	case token == "":
+	case BitbucketUsername != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(BitbucketUsername+":"+token)))
+	default:
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header
+}
+
+// Bitbucket Cloud API 地址，SERVER_URL 为 bitbucket.org 时使用官方 API，否则视为 API 代理地址
+func bitbucketCloudAPI() string {
+	if u, err := url.Parse(ServerURL); err == nil && (u.Host == "bitbucket.org" || u.Host == "api.bitbucket.org") {
+		return "https://api.bitbucket.org/2.0"
+	}
+	return strings.TrimSuffix(ServerURL, "/")
+}
+
+// Bitbucket Cloud 分页响应，next 为下一页的完整 URL
+type bitbucketCloudPage struct {
+	Values []struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+var commitHashPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// 把分支或 tag 解析为提交 SHA：src 接口不支持带 / 的分支名，分页过程中也需要固定的提交
+func bitbucketCloudCommit(ctx context.Context, workspace, repo, ref string) (string, error) {
+	if commitHashPattern.MatchString(ref) {
+		return ref, nil
+	}
+	base := fmt.Sprintf("%s/repositories/%s/%s/refs", bitbucketCloudAPI(), url.PathEscape(workspace), url.PathEscape(repo))
+	var lastErr error
+	for _, kind := range []string{"branches", "tags"} {
+		var resp struct {
+			Target struct {
+				Hash string `json:"hash"`
+			} `json:"target"`
+		}
+		if err := getJSON(ctx, base+"/"+kind+"/"+url.PathEscape(ref), bitbucketHeader(ctx), &resp); err != nil {
+			lastErr = err
+			continue
+		}
+		return resp.Target.Hash, nil
+	}
+	return "", fmt.Errorf("resolve ref %q: %w", ref, lastErr)
+}
+
+// 从 Bitbucket Cloud 获取目录下所有文件
+func fetchFilesFromBitbucket(ctx context.Context, workspace, repo, branch, dir string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "bitbucket.fetch_files", resolvedAttributes(workspace, repo, branch, dir)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from bitbucket",
+		"workspace", workspace, "repo", repo, "branch", branch, "path", dir)
+
+	commit, err := bitbucketCloudCommit(ctx, workspace, repo, branch)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to resolve ref", "error", err)
+		return nil, err
+	}
+
+	srcURL := fmt.Sprintf("%s/repositories/%s/%s/src/%s/", bitbucketCloudAPI(), url.PathEscape(workspace), url.PathEscape(repo), commit)
+
+	// 按 next 链接翻页获取目录内容
+	var paths []string
+	next := srcURL + "?pagelen=100"
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		next = srcURL + escapePath(dir) + "/?pagelen=100"
+	}
+	listCtx, listSpan := startSpan(ctx, "bitbucket.list_contents")
+	for next != "" {
+		// 只跟随同一 API 下的链接，避免把凭据发给其他主机
+		if !strings.HasPrefix(next, bitbucketCloudAPI()+"/") {
+			err = fmt.Errorf("unexpected pagination link %q", next)
+			break
+		}
+		var page bitbucketCloudPage
+		if err = getJSON(listCtx, next, bitbucketHeader(ctx), &page); err != nil {
+			break
+		}
+		for _, value := range page.Values {
+			if value.Type == "commit_file" && isConfigFile(value.Path) {
+				paths = append(paths, value.Path)
+			} else {
+				slog.DebugContext(ctx, "skipping entry", "name", value.Path, "type", value.Type)
+			}
+		}
+		next = page.Next
+	}
+	endSpan(listSpan, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get directory contents", "error", err)
+		return nil, err
+	}
+
+	var result []GiteaFile
+	for _, filePath := range paths {
+		// src 接口对文件直接返回原始内容
+		fileCtx, fileSpan := startSpan(ctx, "bitbucket.get_file", attribute.String("config.file", filePath))
+		data, err := getRaw(fileCtx, srcURL+escapePath(filePath), bitbucketHeader(ctx))
+		endSpan(fileSpan, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to fetch file", "file", filePath, "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    path.Base(filePath),
+			Path:    filePath,
+			Type:    "file",
+			Content: string(data),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", filePath, "bytes", len(data))
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
+	return result, nil
+}
+
+func checkBitbucket(ctx context.Context, workspace, repo string) error {
+	var resp struct {
+		FullName string `json:"full_name"`
+	}
+	if repo != "" {
+		target := fmt.Sprintf("%s/repositories/%s/%s", bitbucketCloudAPI(), url.PathEscape(workspace), url.PathEscape(repo))
+		if err := getJSON(ctx, target, bitbucketHeader(ctx), &resp); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", workspace, repo, err)
+		}
+		return nil
+	}
+	// 没有指定仓库时，只验证凭据是否有效
+	if err := getJSON(ctx, bitbucketCloudAPI()+"/user", bitbucketHeader(ctx), &resp); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	return nil
+}
+
+// Bitbucket Server / Data Center 分页响应
+type bitbucketServerPage struct {
+	Values []struct {
+		Type string `json:"type"`
+		Path struct {
+			Name     string `json:"name"`
+			ToString string `json:"toString"`
+		} `json:"path"`
+	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// Bitbucket Server REST API 地址，namespace 为项目 key（个人仓库为 ~username）
+func bitbucketServerRepoURL(project, repo string) string {
+	return fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s",
+		strings.TrimSuffix(ServerURL, "/"), url.PathEscape(project), url.PathEscape(repo))
+}
+
+// 从 Bitbucket Server / Data Center 获取目录下所有文件
+func fetchFilesFromBitbucketServer(ctx context.Context, project, repo, branch, dir string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "bitbucket_server.fetch_files", resolvedAttributes(project, repo, branch, dir)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from bitbucket server",
+		"project", project, "repo", repo, "branch", branch, "path", dir)
+
+	repoURL := bitbucketServerRepoURL(project, repo)
+	dir = strings.Trim(dir, "/")
+	at := url.QueryEscape(branch)
+
+	// browse 接口按 start/limit 分页
+	var names []string
+	listCtx, listSpan := startSpan(ctx, "bitbucket_server.list_contents")
+	for start := 0; ; {
+		var resp struct {
+			Children bitbucketServerPage `json:"children"`
+		}
+		target := fmt.Sprintf("%s/browse/%s?at=%s&start=%d&limit=100", repoURL, escapePath(dir), at, start)
+		if err = getJSON(listCtx, target, bitbucketHeader(ctx), &resp); err != nil {
+			break
+		}
+		for _, value := range resp.Children.Values {
+			if value.Type == "FILE" && isConfigFile(value.Path.Name) {
+				names = append(names, value.Path.ToString)
+			} else {
+				slog.DebugContext(ctx, "skipping entry", "name", value.Path.ToString, "type", value.Type)
+			}
+		}
+		if resp.Children.IsLastPage || resp.Children.NextPageStart <= start {
+			break
+		}
+		start = resp.Children.NextPageStart
+	}
+	endSpan(listSpan, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get directory contents", "error", err)
+		return nil, err
+	}
+
+	var result []GiteaFile
+	for _, name := range names {
+		filePath := strings.TrimPrefix(dir+"/"+name, "/")
+
+		fileCtx, fileSpan := startSpan(ctx, "bitbucket_server.get_file", attribute.String("config.file", filePath))
+		data, err := getRaw(fileCtx, repoURL+"/raw/"+escapePath(filePath)+"?at="+at, bitbucketHeader(ctx))
+		endSpan(fileSpan, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to fetch file", "file", filePath, "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    path.Base(filePath),
+			Path:    filePath,
+			Type:    "file",
+			Content: string(data),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", filePath, "bytes", len(data))
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
+	return result, nil
+}
+
+func checkBitbucketServer(ctx context.Context, project, repo string) error {
+	var resp struct {
+		Slug string `json:"slug"`
+	}
+	if repo != "" {
+		if err := getJSON(ctx, bitbucketServerRepoURL(project, repo), bitbucketHeader(ctx), &resp); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", project, repo, err)
+		}
+		return nil
+	}
+	var projects struct {
+		Size int `json:"size"`
+	}
+	if err := getJSON(ctx, strings.TrimSuffix(ServerURL, "/")+"/rest/api/1.0/projects?limit=1", bitbucketHeader(ctx), &projects); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	return nil
+}
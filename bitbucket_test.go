@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useBitbucket(t *testing.T, serverType string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	previous := []string{ServerType, ServerURL, Token, BitbucketUsername}
+	ServerType, ServerURL, Token, BitbucketUsername = serverType, srv.URL, "test-token", ""
+	t.Cleanup(func() {
+		ServerType, ServerURL, Token, BitbucketUsername = previous[0], previous[1], previous[2], previous[3]
+	})
+	return srv
+}
+
+func TestFetchFilesFromBitbucketCloud(t *testing.T) {
+	const commit = "9f2c1e7a5d6b4c3a2f1e0d9c8b7a6f5e4d3c2b1a"
+	var srv *httptest.Server
+	srv = useBitbucket(t, "bitbucket", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, `{"type":"error"}`, http.StatusUnauthorized)
+			return
+		}
+		src := "/repositories/team/woodpeckerfiles/src/" + commit + "/"
+		switch r.URL.EscapedPath() + "?" + r.URL.RawQuery {
+		case "/repositories/team/woodpeckerfiles/refs/branches/feature%2Fci?":
+			fmt.Fprintf(w, `{"name":"feature/ci","target":{"hash":%q}}`, commit)
+		case src + "app/main/?pagelen=100":
+			fmt.Fprintf(w, `{"pagelen":2,"page":1,"values":[
+				{"type":"commit_file","path":"app/main/build.yml"},
+				{"type":"commit_directory","path":"app/main/shared"}],
+				"next":"%s%sapp/main/?pagelen=100&page=2"}`, srv.URL, src)
+		case src + "app/main/?pagelen=100&page=2":
+			fmt.Fprint(w, `{"pagelen":2,"page":2,"values":[
+				{"type":"commit_file","path":"app/main/deploy.yaml"},
+				{"type":"commit_file","path":"app/main/README.md"}]}`)
+		case src + "app/main/build.yml?":
+			fmt.Fprint(w, "steps:\n  - name: build\n    image: alpine\n")
+		case src + "app/main/deploy.yaml?":
+			fmt.Fprint(w, "steps:\n  - name: deploy\n    image: alpine\n")
+		case "/repositories/team/woodpeckerfiles?":
+			fmt.Fprint(w, `{"full_name":"team/woodpeckerfiles"}`)
+		default:
+			http.Error(w, `{"type":"error"}`, http.StatusNotFound)
+		}
+	})
+
+	files, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "feature/ci", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "build.yml" || files[1].Path != "app/main/deploy.yaml" ||
+		!strings.Contains(files[1].Content, "name: deploy") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if _, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "missing", "app/main"); err == nil {
+		t.Error("expected error for unknown branch")
+	}
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+}
+
+func TestBitbucketCloudRejectsForeignPaginationLink(t *testing.T) {
+	const commit = "9f2c1e7"
+	useBitbucket(t, "bitbucket", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"values":[],"next":"https://attacker.example.com/steal"}`)
+	})
+
+	_, err := fetchFilesFromBitbucket(context.Background(), "team", "woodpeckerfiles", commit, "app")
+	if err == nil || !strings.Contains(err.Error(), "unexpected pagination link") {
+		t.Errorf("error = %v, want pagination link rejection", err)
+	}
+}
+
+func TestFetchFilesFromBitbucketServer(t *testing.T) {
+	useBitbucket(t, "bitbucket-server", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "ci" || pass != "test-token" {
+			http.Error(w, `{"errors":[]}`, http.StatusUnauthorized)
+			return
+		}
+		repo := "/rest/api/1.0/projects/TEAM/repos/woodpeckerfiles"
+		if r.URL.Query().Get("at") != "release/1.0" && r.URL.Path != repo {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		switch r.URL.Path {
+		case repo + "/browse/app/main":
+			if r.URL.Query().Get("start") == "0" {
+				fmt.Fprint(w, `{"path":{"toString":"app/main"},"children":{"size":2,"limit":2,"start":0,"isLastPage":false,"nextPageStart":2,"values":[
+					{"path":{"components":["build.yml"],"name":"build.yml","toString":"build.yml"},"type":"FILE","size":40},
+					{"path":{"components":["shared"],"name":"shared","toString":"shared"},"type":"DIRECTORY"}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"path":{"toString":"app/main"},"children":{"size":1,"limit":2,"start":2,"isLastPage":true,"values":[
+				{"path":{"components":["test.yml"],"name":"test.yml","toString":"test.yml"},"type":"FILE","size":40}]}}`)
+		case repo + "/raw/app/main/build.yml":
+			fmt.Fprint(w, "steps:\n  - name: build\n    image: alpine\n")
+		case repo + "/raw/app/main/test.yml":
+			fmt.Fprint(w, "steps:\n  - name: test\n    image: alpine\n")
+		case repo:
+			fmt.Fprint(w, `{"slug":"woodpeckerfiles"}`)
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	})
+	BitbucketUsername = "ci"
+
+	files, err := fetchFilesFromGitServer(context.Background(), "TEAM", "woodpeckerfiles", "release/1.0", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Path != "app/main/build.yml" || files[1].Path != "app/main/test.yml" ||
+		!strings.Contains(files[1].Content, "name: test") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if err := checkGitServer(context.Background(), "TEAM", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+	Token = "wrong"
+	if err := checkGitServer(context.Background(), "TEAM", "woodpeckerfiles"); err == nil {
+		t.Error("expected auth error")
+	}
+}
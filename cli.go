@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const cliUsage = `Usage: woodpecker-config-provider [command] [flags]
+
+Commands:
+  serve    启动 HTTP 服务（默认）
+  render   离线模拟一次 /ciconfig 请求，输出解析结果
+  validate 检查本地的配置仓库（目录结构、YAML 语法、schema 和策略）
+  help     显示帮助
+
+运行 "woodpecker-config-provider <command> -h" 查看子命令参数。
+`
+
+// 命令行入口，返回进程退出码
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		return runServe(args, stderr)
+	case "render":
+		return runRender(args, stdout, stderr)
+	case "validate":
+		return runValidate(args, stdout, stderr)
+	case "help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, cliUsage)
+		return 2
+	}
+}
+
+// render 子命令的 JSON 输出
+type renderOutput struct {
+	resolution
+	Error string `json:"error,omitempty"`
+}
+
+// render 子命令：构造 ConfigRequest，按与 /ciconfig 相同的流程解析配置
+func runRender(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		requestFile = fs.String("request", "", "从 JSON 文件读取完整的 Woodpecker 请求（其余参数覆盖其中的值）")
+		repo        = fs.String("repo", "", "仓库全名，格式 owner/name")
+		branch      = fs.String("branch", "main", "pipeline 分支")
+		event       = fs.String("event", "push", "pipeline 事件，如 push/pull_request/tag/deployment")
+		commit      = fs.String("commit", "", "提交 SHA")
+		ref         = fs.String("ref", "", "Git ref，默认 refs/heads/<branch>")
+		jsonOutput  = fs.Bool("json", false, "以 JSON 输出结果")
+	)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// 日志输出到 stderr，避免污染结果
+	setupLogger(stderr)
+	if err := setupCredentials(); err != nil {
+		fmt.Fprintf(stderr, "load credentials: %v\n", err)
+		return 2
+	}
+	if err := setupTemplates(); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	var req ConfigRequest
+	if *requestFile != "" {
+		data, err := os.ReadFile(*requestFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "read request: %v\n", err)
+			return 2
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			fmt.Fprintf(stderr, "parse request: %v\n", err)
+			return 2
+		}
+	}
+
+	// 只覆盖显式设置的参数；没有请求文件时全部使用参数（含默认值）
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	useFlag := func(name string) bool { return *requestFile == "" || set[name] }
+
+	if useFlag("repo") {
+		owner, name, ok := strings.Cut(*repo, "/")
+		if !ok || owner == "" || name == "" {
+			fmt.Fprintln(stderr, "--repo must be in the form owner/name")
+			return 2
+		}
+		req.Repo.Owner, req.Repo.Name, req.Repo.FullName = owner, name, *repo
+	}
+	if useFlag("branch") {
+		req.Pipeline.Branch = *branch
+	}
+	if useFlag("event") {
+		req.Pipeline.Event = *event
+	}
+	if useFlag("commit") {
+		req.Pipeline.Commit = *commit
+	}
+	if useFlag("ref") || set["branch"] || req.Pipeline.Ref == "" {
+		req.Pipeline.Ref = *ref
+		if req.Pipeline.Ref == "" {
+			req.Pipeline.Ref = "refs/heads/" + req.Pipeline.Branch
+		}
+	}
+
+	ctx := withRequestID(context.Background(), "render")
+	res, err := resolveConfig(ctx, req)
+
+	exitCode := 0
+	if err != nil || len(res.Errors) > 0 {
+		exitCode = 1
+	}
+
+	if *jsonOutput {
+		out := renderOutput{resolution: *res}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return exitCode
+	}
+
+	fmt.Fprintf(stdout, "Namespace: %s\n", res.Namespace)
+	fmt.Fprintf(stdout, "Repo:      %s\n", res.Repo)
+	fmt.Fprintf(stdout, "Branch:    %s\n", res.Branch)
+	fmt.Fprintf(stdout, "Path:      %s\n", res.Path)
+	if len(res.Layers) > 0 {
+		fmt.Fprintf(stdout, "Layers:    %s\n", strings.Join(res.Layers, ", "))
+	}
+
+	if err != nil {
+		fmt.Fprintf(stdout, "\nError: %v\n", err)
+		fmt.Fprintln(stdout, "(Woodpecker 会收到 204，使用仓库自身的配置)")
+		return exitCode
+	}
+
+	fmt.Fprintf(stdout, "\nConfigs: %d\n", len(res.Configs))
+	for _, config := range res.Configs {
+		fmt.Fprintf(stdout, "\n--- %s (%d bytes)\n%s", config.Name, len(config.Data), config.Data)
+		if !strings.HasSuffix(config.Data, "\n") {
+			fmt.Fprintln(stdout)
+		}
+	}
+	for _, fe := range res.Errors {
+		fmt.Fprintf(stdout, "\nError: %s: %s\n", fe.File, fe.Error)
+	}
+	return exitCode
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 模拟 GitHub Enterprise contents API，files 的 key 为仓库内路径
+func newFakeGitHub(t *testing.T, owner, repo string, files map[string]string) {
+	t.Helper()
+
+	prefix := "/api/v3/repos/" + owner + "/" + repo + "/contents/"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		p := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+		if content, ok := files[p]; ok {
+			json.NewEncoder(w).Encode(map[string]string{
+				"type":     "file",
+				"name":     filepath.Base(p),
+				"path":     p,
+				"encoding": "base64",
+				"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+			})
+			return
+		}
+
+		var entries []map[string]string
+		for name := range files {
+			if filepath.Dir(name) == p {
+				entries = append(entries, map[string]string{"type": "file", "name": filepath.Base(name), "path": name})
+			}
+		}
+		if entries == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i]["name"] < entries[j]["name"] })
+		json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(server.Close)
+
+	previousType, previousURL := ServerType, ServerURL
+	ServerType, ServerURL = "github", server.URL
+	t.Cleanup(func() { ServerType, ServerURL = previousType, previousURL })
+}
+
+func TestRenderCommand(t *testing.T) {
+	newFakeGitHub(t, "team", "woodpeckerfiles", map[string]string{
+		"app/main/build.yml": "steps:\n  - name: build\n    image: golang\n",
+		"app/main/test.yaml": "steps:\n  - name: test\n    image: golang\n",
+		"app/main/README.md": "# ignored",
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := runCLI([]string{"render", "--repo", "team/app", "--branch", "main"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr:\n%s", code, stderr.String())
+	}
+
+	out := stdout.String()
+	for _, want := range []string{"Namespace: team", "Path:      app/main", "Configs: 2", "--- build", "--- test", "image: golang"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderCommandJSON(t *testing.T) {
+	newFakeGitHub(t, "team", "woodpeckerfiles", map[string]string{
+		"app/develop/build.yml": "steps:\n  - name: build\n    image: golang\n",
+		"app/develop/bad.yml":   "steps: [\n",
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := runCLI([]string{"render", "--repo", "team/app", "--branch", "develop", "--json"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1 for invalid YAML", code)
+	}
+
+	var out renderOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON output: %v\n%s", err, stdout.String())
+	}
+	if out.Path != "app/develop" || out.Branch != "develop" {
+		t.Fatalf("unexpected resolution: %s", stdout.String())
+	}
+	if len(out.Configs) != 2 || len(out.Errors) != 1 || out.Errors[0].File != "app/develop/bad.yml" {
+		t.Errorf("configs = %d, errors = %+v", len(out.Configs), out.Errors)
+	}
+}
+
+func TestRenderCommandFetchError(t *testing.T) {
+	newFakeGitHub(t, "team", "woodpeckerfiles", map[string]string{})
+
+	var stdout, stderr bytes.Buffer
+	code := runCLI([]string{"render", "--repo", "team/missing", "--json"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+
+	var out renderOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if out.Error == "" || out.Path != "missing/main" {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestRenderCommandRequestFile(t *testing.T) {
+	newFakeGitHub(t, "org", "woodpeckerfiles", map[string]string{
+		"svc/release/deploy.yml": "steps:\n  - name: deploy\n    image: alpine\n",
+	})
+
+	requestFile := filepath.Join(t.TempDir(), "request.json")
+	payload := `{"repo":{"name":"svc","owner":"org","full_name":"org/svc"},"pipeline":{"branch":"main","event":"push"}}`
+	if err := os.WriteFile(requestFile, []byte(payload), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCLI([]string{"render", "--request", requestFile, "--branch", "release", "--json"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stdout:\n%s\nstderr:\n%s", code, stdout.String(), stderr.String())
+	}
+
+	var out renderOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if out.Path != "svc/release" || len(out.Configs) != 1 || out.Configs[0].Name != "deploy" {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"bogus"}, &stdout, &stderr); code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "Usage") {
+		t.Errorf("usage not printed: %s", stderr.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 按渲染后的 namespace 或 namespace/repo 选择的凭据，未设置的字段沿用默认凭据
+type credential struct {
+	Match                   string `yaml:"match" json:"match"`
+	Token                   string `yaml:"token" json:"token"`
+	TokenFile               string `yaml:"token_file" json:"token_file"`
+	GitHubAppInstallationID int64  `yaml:"github_app_installation_id" json:"github_app_installation_id"`
+	GitLabOAuthToken        string `yaml:"gitlab_oauth_token" json:"gitlab_oauth_token"`
+	GitLabOAuthTokenFile    string `yaml:"gitlab_oauth_token_file" json:"gitlab_oauth_token_file"`
+
+	tokenFile      *secretFile
+	oauthTokenFile *secretFile
+}
+
+// CREDENTIALS_FILE 中的映射，按顺序匹配，第一个命中的生效
+var credentials []*credential
+
+// 读取并校验凭据映射（YAML 或 JSON 列表）
+func loadCredentials(file string) ([]*credential, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read CREDENTIALS_FILE: %w", err)
+	}
+	var list []*credential
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse CREDENTIALS_FILE: %w", err)
+	}
+
+	for i, c := range list {
+		if c == nil || c.Match == "" {
+			return nil, fmt.Errorf("credentials[%d]: match is required", i)
+		}
+		if _, err := path.Match(c.Match, ""); err != nil {
+			return nil, fmt.Errorf("credentials[%d]: invalid match %q: %w", i, c.Match, err)
+		}
+		if c.Token != "" && c.TokenFile != "" {
+			return nil, fmt.Errorf("credentials[%d]: token and token_file are mutually exclusive", i)
+		}
+		if c.GitLabOAuthToken != "" && c.GitLabOAuthTokenFile != "" {
+			return nil, fmt.Errorf("credentials[%d]: gitlab_oauth_token and gitlab_oauth_token_file are mutually exclusive", i)
+		}
+		if c.GitHubAppInstallationID != 0 && !githubAppEnabled() {
+			return nil, fmt.Errorf("credentials[%d]: github_app_installation_id requires GITHUB_APP_ID and a private key", i)
+		}
+		if c.Token == "" && c.TokenFile == "" && c.GitHubAppInstallationID == 0 &&
+			c.GitLabOAuthToken == "" && c.GitLabOAuthTokenFile == "" {
+			return nil, fmt.Errorf("credentials[%d]: no credential configured for %q", i, c.Match)
+		}
+
+		registerSecret(c.Token)
+		registerSecret(c.GitLabOAuthToken)
+		if c.TokenFile != "" {
+			c.tokenFile = &secretFile{name: c.Match + " token_file", path: c.TokenFile}
+		}
+		if c.GitLabOAuthTokenFile != "" {
+			c.oauthTokenFile = &secretFile{name: c.Match + " gitlab_oauth_token_file", path: c.GitLabOAuthTokenFile}
+		}
+	}
+	return list, nil
+}
+
+// 启动时加载 CREDENTIALS_FILE，未设置时只使用默认凭据
+func setupCredentials() error {
+	if CredentialsFile == "" {
+		return nil
+	}
+	list, err := loadCredentials(CredentialsFile)
+	if err != nil {
+		return err
+	}
+	credentials = list
+	slog.Info("credentials mapping loaded", "entries", len(list))
+	return nil
+}
+
+// 查找 namespace 或 namespace/repo 匹配的凭据，* 不跨越 /
+func matchCredential(namespace, repo string) *credential {
+	for _, c := range credentials {
+		if ok, _ := path.Match(c.Match, namespace); ok {
+			return c
+		}
+		if ok, _ := path.Match(c.Match, namespace+"/"+repo); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *credential) token() string {
+	if c.tokenFile != nil {
+		return c.tokenFile.get()
+	}
+	return c.Token
+}
+
+func (c *credential) gitlabOAuthToken() string {
+	if c.oauthTokenFile != nil {
+		return c.oauthTokenFile.get()
+	}
+	return c.GitLabOAuthToken
+}
+
+type credentialKey struct{}
+
+// 在 context 中记录本次请求选中的凭据
+func withCredential(ctx context.Context, c *credential) context.Context {
+	return context.WithValue(ctx, credentialKey{}, c)
+}
+
+// 按配置仓库选择凭据，没有匹配时使用默认凭据
+func withCredentials(ctx context.Context, namespace, repo string) context.Context {
+	c := matchCredential(namespace, repo)
+	if c != nil {
+		slog.DebugContext(ctx, "using mapped credentials", "match", c.Match, "namespace", namespace, "repo", repo)
+	}
+	return withCredential(ctx, c)
+}
+
+func credentialFromContext(ctx context.Context) *credential {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(credentialKey{}).(*credential)
+	return c
+}
+
+// 映射中的访问令牌，没有时返回空
+func mappedToken(ctx context.Context) string {
+	if c := credentialFromContext(ctx); c != nil {
+		return c.token()
+	}
+	return ""
+}
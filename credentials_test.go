@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCredentials(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "credentials.yml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"yaml", "- match: team-a\n  token: a-token\n- match: 'team-b/*'\n  gitlab_oauth_token: b-oauth\n", ""},
+		{"json", `[{"match":"team-a","token_file":"/run/secrets/team-a"}]`, ""},
+		{"missing match", "- token: a-token\n", "match is required"},
+		{"bad pattern", "- match: '[team'\n  token: a-token\n", "invalid match"},
+		{"token and file", "- match: team-a\n  token: a\n  token_file: /a\n", "mutually exclusive"},
+		{"empty entry", "- match: team-a\n", "no credential configured"},
+		{"installation without app", "- match: team-a\n  github_app_installation_id: 7\n", "requires GITHUB_APP_ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCredentials(writeCredentials(t, tt.content))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("loadCredentials() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("loadCredentials() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchCredential(t *testing.T) {
+	list, err := loadCredentials(writeCredentials(t, `
+- match: team-a/secret-*
+  token: secret-token
+- match: team-a
+  token: a-token
+- match: 'group/*/*'
+  gitlab_oauth_token: nested-oauth
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := credentials
+	credentials = list
+	t.Cleanup(func() { credentials = previous })
+
+	tests := []struct {
+		namespace, repo string
+		want            string
+	}{
+		{"team-a", "secret-files", "team-a/secret-*"},
+		{"team-a", "woodpeckerfiles", "team-a"},
+		{"group/subgroup", "woodpeckerfiles", "group/*/*"},
+		{"team-b", "woodpeckerfiles", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c := matchCredential(tt.namespace, tt.repo); c != nil {
+			got = c.Match
+		}
+		if got != tt.want {
+			t.Errorf("matchCredential(%q, %q) = %q, want %q", tt.namespace, tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestFetchUsesMappedCredentials(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "team-a")
+	if err := os.WriteFile(tokenFile, []byte("a-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := loadCredentials(writeCredentials(t, fmt.Sprintf("- match: team-a\n  token_file: %s\n", tokenFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := credentials
+	credentials = list
+	t.Cleanup(func() { credentials = previous })
+
+	// 每个组织只接受自己的令牌
+	want := map[string]string{"team-a": "Bearer a-token", "team-b": "Bearer default-token"}
+	useHTTPSource(t, func(w http.ResponseWriter, r *http.Request) {
+		namespace, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if r.Header.Get("Authorization") != want[namespace] {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/"):
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `["build.yml"]`)
+		default:
+			fmt.Fprint(w, "steps:\n  - name: build\n    image: alpine\n")
+		}
+	})
+	Token, HTTPHeaders = "default-token", ""
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		files, err := fetchFilesFromGitServer(context.Background(), namespace, "woodpeckerfiles", "main", "app")
+		if err != nil || len(files) != 1 {
+			t.Errorf("%s: files = %+v, error = %v", namespace, files, err)
+		}
+	}
+}
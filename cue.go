@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cueerrors "cuelang.org/go/cue/errors"
+	cueyaml "cuelang.org/go/encoding/yaml"
+)
+
+// CUE pipeline 文件，每个文件单独求值
+func isCUEFile(name string) bool {
+	return strings.HasSuffix(name, ".cue")
+}
+
+// 请求数据注入的定义，文件中可以用 #request 引用，也可以给它加约束
+const cueRequestField = "#request"
+
+// 追加到文件末尾，未声明 #request 的文件也可以引用它；与文件中的声明合一
+const cueRequestDecl = "\n" + cueRequestField + ": _\n"
+
+// 编译 CUE 文件，只检查语法和合一冲突，不要求具体值
+func compileCUE(file GiteaFile) (cue.Value, error) {
+	v := cuecontext.New().CompileString(file.Content+cueRequestDecl, cue.Filename(file.Path))
+	if err := v.Err(); err != nil {
+		return v, err
+	}
+	if err := v.Validate(); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// 求值一个 .cue 文件：注入请求后导出为 YAML。
+// 顶层有 pipelines 列表时每个元素生成一个 pipeline（名称加 -1、-2 后缀），否则整个文件是一个 pipeline
+func evaluateCUE(file GiteaFile, data TemplateData, location fileTemplateLocation) ([]ConfigFile, error) {
+	input, err := generatorInput(data, location)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := compileCUE(file)
+	if err != nil {
+		return nil, fmt.Errorf("cue: %s", cueErrorMessage(err))
+	}
+	v = v.FillPath(cue.MakePath(cue.Def(cueRequestField)), input)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return nil, fmt.Errorf("cue: %s", cueErrorMessage(err))
+	}
+
+	name := pipelineName(file.Name)
+	docs := []cue.Value{v}
+	if pipelines := v.LookupPath(cue.ParsePath("pipelines")); pipelines.Exists() {
+		iter, err := pipelines.List()
+		if err != nil {
+			return nil, fmt.Errorf("cue: %s: pipelines must be a list", file.Path)
+		}
+		docs = nil
+		for iter.Next() {
+			docs = append(docs, iter.Value())
+		}
+		if len(docs) == 0 {
+			return nil, fmt.Errorf("cue: %s produced an empty list", file.Path)
+		}
+	}
+
+	var configs []ConfigFile
+	for i, doc := range docs {
+		if doc.Kind() != cue.StructKind {
+			return nil, fmt.Errorf("cue: %s must produce a struct or a list of structs", file.Path)
+		}
+		b, err := cueyaml.Encode(doc)
+		if err != nil {
+			return nil, fmt.Errorf("cue: %s", cueErrorMessage(err))
+		}
+		configName := name
+		if len(docs) > 1 {
+			configName = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		configs = append(configs, ConfigFile{Name: configName, Data: string(b)})
+	}
+	return configs, nil
+}
+
+// 每个错误带上位置（file:line:col），合并为一行
+func cueErrorMessage(err error) string {
+	var parts []string
+	for _, e := range cueerrors.Errors(err) {
+		msg := cueerrors.String(e)
+		for _, pos := range cueerrors.Positions(e) {
+			msg += " (" + pos.String() + ")"
+		}
+		parts = append(parts, msg)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// 校验错误转换为带行号的诊断信息
+func cueDiagnostics(file string, err error) []diagnostic {
+	var diags []diagnostic
+	for _, e := range cueerrors.Errors(err) {
+		line := 0
+		if positions := cueerrors.Positions(e); len(positions) > 0 {
+			line = positions[0].Line()
+		}
+		diags = append(diags, diagnostic{File: file, Line: line, Severity: severityError, Message: cueerrors.String(e)})
+	}
+	return diags
+}
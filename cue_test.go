@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateCUE(t *testing.T) {
+	data := TemplateData{
+		Repo:     RepoInfo{Owner: "team", Name: "app"},
+		Pipeline: PipelineInfo{Branch: "main", Event: "push"},
+	}
+	location := fileTemplateLocation{Path: "app/main"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "request values and defaults",
+			content: "#Step: {name: string, image: string | *\"golang:1.24\"}\nsteps: [#Step & {name: \"test\"}, #Step & {name: #request.repo.name, image: \"alpine\"}]\nwhen: branch: #request.pipeline.branch\n",
+			want:    map[string]string{"build": "steps:\n  - name: test\n    image: golang:1.24\n  - name: app\n    image: alpine\nwhen:\n  branch: main\n"},
+		},
+		{
+			name:    "pipelines list",
+			content: "pipelines: [for n in [\"a\", \"b\"] {steps: [{name: n, image: #request.config.Path}]}]\n",
+			want: map[string]string{
+				"build-1": "steps:\n  - name: a\n    image: app/main\n",
+				"build-2": "steps:\n  - name: b\n    image: app/main\n",
+			},
+		},
+		{
+			name:    "constraints on the request",
+			content: "#request: pipeline: branch: \"release\"\nsteps: []\n",
+			wantErr: "conflicting values",
+		},
+		{
+			name:    "unification errors include file and line",
+			content: "steps: [{name: \"test\"}]\nsteps: [{name: 1}]\n",
+			wantErr: "app/main/build.cue:2:",
+		},
+		{
+			name:    "incomplete values",
+			content: "steps: [{name: string}]\n",
+			wantErr: "incomplete value string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := GiteaFile{Name: "build.cue", Path: "app/main/build.cue", Content: tt.content}
+			configs, err := evaluateCUE(file, data, location)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evaluateCUE() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluateCUE() error = %v", err)
+			}
+			if len(configs) != len(tt.want) {
+				t.Fatalf("got %d configs, want %d: %+v", len(configs), len(tt.want), configs)
+			}
+			for _, c := range configs {
+				if c.Data != tt.want[c.Name] {
+					t.Errorf("config %s =\n%s\nwant:\n%s", c.Name, c.Data, tt.want[c.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveConfigCUE(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/app/main/build.cue":  "steps: [{name: \"build\", image: \"golang\", commands: [\"echo \\(#request.repo.full_name)\"]}]\n",
+		"team/woodpeckerfiles/main/app/main/broken.cue": "steps: [{name: \"a\"}] & [{name: \"b\"}]\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+	if len(res.Configs) != 1 || res.Configs[0].Name != "build" || !strings.Contains(res.Configs[0].Data, "echo team/app") {
+		t.Errorf("unexpected configs: %+v", res.Configs)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].File != "app/main/broken.cue" {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestValidateTreeCUE(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.cue":  "#Step: {name: string}\nsteps: [#Step & {name: #request.repo.name}]\n",
+		"app/main/broken.cue": "#Step: {name: string}\nsteps: [#Step & {name: 1}]\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) == 0 {
+		t.Fatal("expected diagnostics for broken.cue")
+	}
+	for _, d := range diags {
+		if d.File != "app/main/broken.cue" || d.Line == 0 {
+			t.Errorf("unexpected diagnostic: %+v", d)
+		}
+	}
+}
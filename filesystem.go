@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// FILE_PATH_TEMPLATE 可用的变量，对应解析后的 namespace/repo/branch/path
+type fileLocation struct {
+	Namespace string
+	Repo      string
+	Branch    string
+	Path      string
+}
+
+// 把解析后的位置映射为 CONFIG_DIR 下的目录，拒绝跳出 CONFIG_DIR 的路径
+func resolveDir(namespace, repo, branch, path string) (string, error) {
+	tmpl, err := template.New("file").Option("missingkey=error").Parse(FilePathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parse FILE_PATH_TEMPLATE: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fileLocation{Namespace: namespace, Repo: repo, Branch: branch, Path: path}); err != nil {
+		return "", fmt.Errorf("execute FILE_PATH_TEMPLATE: %w", err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash("/" + buf.String()))
+	for _, part := range []string{namespace, repo, branch, path} {
+		if strings.Contains(part, "..") {
+			return "", fmt.Errorf("invalid path component %q", part)
+		}
+	}
+
+	root, err := filepath.Abs(ConfigDir)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, rel)
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q escapes CONFIG_DIR", buf.String())
+	}
+	return dir, nil
+}
+
+// 从本地目录（ConfigMap、git-sync 卷等）获取目录下所有文件
+func fetchFilesFromDir(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "file.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	dir, err := resolveDir(namespace, repo, branch, path)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "fetch files from directory", "dir", dir)
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = notFoundError(err)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read directory", "dir", dir, "error", err)
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "found items in directory", "count", len(entries))
+
+	var result []GiteaFile
+	for _, entry := range entries {
+		// ConfigMap 挂载的文件是指向 ..data 的符号链接，用 os.Stat 跟随
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil || !info.Mode().IsRegular() || !isConfigFile(entry.Name()) {
+			slog.DebugContext(ctx, "skipping entry", "name", entry.Name())
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read file", "file", entry.Name(), "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    entry.Name(),
+			Path:    strings.TrimPrefix(path+"/"+entry.Name(), "/"),
+			Type:    "file",
+			Content: string(content),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", entry.Name(), "bytes", len(content))
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
+	return result, nil
+}
+
+// 就绪检查：CONFIG_DIR 存在且可读
+func checkDir(ctx context.Context) error {
+	info, err := os.Stat(ConfigDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("CONFIG_DIR %s is not a directory", ConfigDir)
+	}
+	if _, err := os.ReadDir(ConfigDir); err != nil {
+		return err
+	}
+	return nil
+}
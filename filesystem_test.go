@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 切换到本地目录配置源
+func useConfigDir(t *testing.T, root, pathTemplate string) {
+	t.Helper()
+	previousType, previousDir, previousTemplate := ServerType, ConfigDir, FilePathTemplate
+	ServerType, ConfigDir, FilePathTemplate = "file", root, pathTemplate
+	t.Cleanup(func() { ServerType, ConfigDir, FilePathTemplate = previousType, previousDir, previousTemplate })
+}
+
+func TestFetchFilesFromDir(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/app/main/build.yml":   "steps:\n  - name: build\n    image: alpine\n",
+		"team/woodpeckerfiles/main/app/main/deploy.yaml": "steps:\n  - name: deploy\n    image: alpine\n",
+		"team/woodpeckerfiles/main/app/main/notes.txt":   "ignored",
+		"team/woodpeckerfiles/main/app/main/sub/x.yml":   "ignored: true\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	files, err := fetchFilesFromDir(context.Background(), "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil {
+		t.Fatalf("fetchFilesFromDir() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2: %+v", len(files), files)
+	}
+	if files[0].Name != "build.yml" || files[0].Path != "app/main/build.yml" || !strings.Contains(files[0].Content, "name: build") {
+		t.Errorf("unexpected file: %+v", files[0])
+	}
+
+	if _, err := fetchFilesFromDir(context.Background(), "team", "woodpeckerfiles", "main", "app/missing"); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
+func TestFetchFilesFromDirRejectsTraversal(t *testing.T) {
+	useConfigDir(t, t.TempDir(), "{{ .Path }}")
+
+	for _, path := range []string{"../etc", "app/../../etc", ".."} {
+		if _, err := fetchFilesFromDir(context.Background(), "team", "repo", "main", path); err == nil ||
+			!strings.Contains(err.Error(), "invalid path component") {
+			t.Errorf("path %q: error = %v, want traversal rejection", path, err)
+		}
+	}
+}
+
+func TestConfigRequestWithFileBackend(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.yml": "steps:\n  - name: build\n    image: alpine\n",
+		"app/main/test.yml":  "steps:\n  - name: test\n    image: alpine\n",
+	})
+	// git-sync 场景：CONFIG_DIR 就是配置仓库的检出目录
+	useConfigDir(t, root, "{{ .Path }}")
+
+	body := `{"repo":{"name":"app","owner":"team"},"pipeline":{"branch":"main"}}`
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ciconfig", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var resp ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Configs) != 2 || resp.Configs[0].Name != "build" || resp.Configs[1].Name != "test" {
+		t.Errorf("unexpected configs: %+v", resp.Configs)
+	}
+
+	// 没有对应目录时返回 204
+	body = `{"repo":{"name":"other","owner":"team"},"pipeline":{"branch":"main"}}`
+	rec = httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ciconfig", strings.NewReader(body)))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+}
+
+func TestCheckDir(t *testing.T) {
+	useConfigDir(t, t.TempDir(), "{{ .Path }}")
+	if err := checkGitServer(context.Background(), "", ""); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+
+	ConfigDir = ConfigDir + "/missing"
+	if err := checkGitServer(context.Background(), "", ""); err == nil {
+		t.Error("expected error for missing CONFIG_DIR")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// 文件首行的模板标记，适用于不方便改后缀的 .yml 文件
+const fileTemplateMarker = "# woodpecker-config: template"
+
+// 模板文件后缀，渲染后按普通 YAML 处理
+var fileTemplateSuffixes = []string{".yml.tmpl", ".yaml.tmpl"}
+
+// 文件模板可用的数据：位置模板的全部数据，加上 TEMPLATE_VARS、当前文件与解析出的配置位置
+type fileTemplateData struct {
+	TemplateData
+	Vars   map[string]string
+	File   fileTemplateFile
+	Config fileTemplateLocation
+}
+
+type fileTemplateFile struct {
+	Name string // 相对配置目录的文件名，如 build.yml.tmpl
+	Path string // 仓库内路径
+}
+
+type fileTemplateLocation struct {
+	Namespace string
+	Repo      string
+	Branch    string
+	Path      string
+}
+
+// 是否需要把文件内容作为模板渲染
+func isFileTemplate(name, content string) bool {
+	for _, suffix := range fileTemplateSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	firstLine, _, _ := strings.Cut(content, "\n")
+	return strings.EqualFold(strings.TrimSpace(firstLine), fileTemplateMarker)
+}
+
+// 去掉 .tmpl 和 .yml/.yaml/.jsonnet/.star/.cue 后缀作为 pipeline 名称
+func pipelineName(name string) string {
+	name = strings.TrimSuffix(name, ".jsonnet")
+	name = strings.TrimSuffix(name, ".star")
+	name = strings.TrimSuffix(name, ".cue")
+	name = strings.TrimSuffix(name, ".tmpl")
+	name = strings.TrimSuffix(name, ".yml")
+	name = strings.TrimSuffix(name, ".yaml")
+	return strings.TrimSuffix(name, ".matrix")
+}
+
+// 解析 TEMPLATE_VARS，每行一个 "KEY=value"，也可以用字面量 \n 分隔
+func parseTemplateVars(s string) (map[string]string, error) {
+	vars := make(map[string]string)
+	s = strings.ReplaceAll(s, `\n`, "\n")
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid TEMPLATE_VARS entry %q, want \"KEY=value\"", line)
+		}
+		vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return vars, nil
+}
+
+// 解析文件模板；文件内容随提交变化，不做缓存
+func parseFileTemplate(name, content string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplateFields(tmpl.Tree.Root, reflect.TypeOf(fileTemplateData{}), true); err != nil {
+		return nil, fmt.Errorf("template: %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// 渲染一个 pipeline 文件
+func renderFileTemplate(file GiteaFile, data TemplateData, location fileTemplateLocation) (string, error) {
+	vars, err := parseTemplateVars(TemplateVars)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := parseFileTemplate(file.Name, file.Content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, fileTemplateData{
+		TemplateData: data,
+		Vars:         vars,
+		File:         fileTemplateFile{Name: file.Name, Path: file.Path},
+		Config:       location,
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
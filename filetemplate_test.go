@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIsFileTemplate(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          bool
+	}{
+		{"build.yml.tmpl", "steps: []\n", true},
+		{"build.yaml.tmpl", "steps: []\n", true},
+		{"build.yml", "# woodpecker-config: template\nsteps: []\n", true},
+		{"build.yml", "steps: []\n# woodpecker-config: template\n", false},
+		{"build.yml", "steps: []\n", false},
+	}
+	for _, tt := range tests {
+		if got := isFileTemplate(tt.name, tt.content); got != tt.want {
+			t.Errorf("isFileTemplate(%q, %q) = %v, want %v", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParseTemplateVars(t *testing.T) {
+	vars, err := parseTemplateVars(`REGISTRY=registry.example.com\nGO_VERSION = 1.24`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["REGISTRY"] != "registry.example.com" || vars["GO_VERSION"] != "1.24" {
+		t.Errorf("unexpected vars: %v", vars)
+	}
+	if _, err := parseTemplateVars("no-equals"); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+}
+
+func TestResolveConfigRendersFileTemplates(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/app/main/build.yml.tmpl":   "steps:\n  - name: build\n    image: {{ .Vars.REGISTRY }}/{{ .Repo.Name }}:{{ trunc 7 .Pipeline.Commit }}\n",
+		"team/woodpeckerfiles/main/app/main/deploy.yml":       "# woodpecker-config: template\nsteps:\n  - name: deploy\n    image: alpine\n    commands:\n      - echo {{ .Config.Path }}/{{ .File.Name }}\n",
+		"team/woodpeckerfiles/main/app/main/plain.yml":        "steps:\n  - name: plain\n    image: alpine\n    commands:\n      - echo '{{ not rendered }}'\n",
+		"team/woodpeckerfiles/main/app/main/broken.yml.tmpl":  "steps:\n  - name: broken\n    image: {{ .Repo.Nmae }}\n",
+		"team/woodpeckerfiles/main/app/main/missing.yml.tmpl": "steps:\n  - name: missing\n    image: {{ .Vars.UNDEFINED }}\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+	previous := TemplateVars
+	TemplateVars = "REGISTRY=registry.example.com"
+	t.Cleanup(func() { TemplateVars = previous })
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main", Commit: "9f2d4c1b7e0a5d3c8b6f"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+
+	configs := make(map[string]string)
+	for _, c := range res.Configs {
+		configs[c.Name] = c.Data
+	}
+	if !strings.Contains(configs["build"], "image: registry.example.com/app:9f2d4c1") {
+		t.Errorf("build not rendered:\n%s", configs["build"])
+	}
+	if !strings.Contains(configs["deploy"], "echo app/main/deploy.yml") {
+		t.Errorf("deploy not rendered:\n%s", configs["deploy"])
+	}
+	if !strings.Contains(configs["plain"], "{{ not rendered }}") {
+		t.Errorf("plain file should be passed through verbatim:\n%s", configs["plain"])
+	}
+
+	// 渲染失败的文件单独报告，不影响其他文件
+	if _, ok := configs["broken"]; ok {
+		t.Error("broken template should be skipped")
+	}
+	errors := make(map[string]string)
+	for _, e := range res.Errors {
+		errors[e.File] = e.Error
+	}
+	if !strings.Contains(errors["app/main/broken.yml.tmpl"], "unknown field .Repo.Nmae") {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+	if !strings.Contains(errors["app/main/missing.yml.tmpl"], "UNDEFINED") {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestValidateTreeFileTemplates(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.yml.tmpl":  "steps:\n  - name: build\n    image: {{ .Repo.Name }}\n",
+		"app/main/broken.yml.tmpl": "steps:\n  - name: broken\n    image: {{ .Repo.Name\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].File != "app/main/broken.yml.tmpl" {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
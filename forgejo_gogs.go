@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/hashicorp/go-version"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Forgejo 的 /api/v1/version 返回自身版本（如 7.0.5+gitea-1.21.11），
+// 需要换算成兼容的 Gitea 版本后再交给 Gitea SDK 做特性判断
+var forgejoVersions = struct {
+	sync.Mutex
+	byURL map[string]string
+}{byURL: make(map[string]string)}
+
+// 把 Forgejo 版本号换算成 Gitea SDK 使用的兼容版本，空字符串表示跳过版本判断
+func parseForgejoVersion(v string) (string, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if v == "" {
+		return "", fmt.Errorf("empty forgejo version")
+	}
+
+	// 7.0 以后带 +gitea-X.Y.Z 后缀
+	if _, compat, ok := strings.Cut(v, "+gitea-"); ok {
+		if _, err := version.NewVersion(compat); err != nil {
+			return "", fmt.Errorf("invalid gitea compatibility version %q: %w", compat, err)
+		}
+		return compat, nil
+	}
+
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid forgejo version %q: %w", v, err)
+	}
+	// 1.x（如 1.21.11-1）沿用 Gitea 的版本号，去掉 Forgejo 的修订号
+	if parsed.Segments()[0] < 7 {
+		segments := parsed.Segments()
+		return fmt.Sprintf("%d.%d.%d", segments[0], segments[1], segments[2]), nil
+	}
+	// 没有兼容后缀的新版本，按最新 Gitea API 处理
+	return "", nil
+}
+
+// 查询并缓存 Forgejo 的兼容 Gitea 版本，SERVER_VERSION 可跳过探测
+func forgejoGiteaVersion(ctx context.Context) (string, error) {
+	if ServerVersion != "" {
+		return parseForgejoVersion(ServerVersion)
+	}
+
+	forgejoVersions.Lock()
+	defer forgejoVersions.Unlock()
+	if v, ok := forgejoVersions.byURL[GiteaURL]; ok {
+		return v, nil
+	}
+
+	var resp struct {
+		Version string `json:"version"`
+	}
+	if err := getJSON(ctx, strings.TrimSuffix(GiteaURL, "/")+"/api/v1/version", giteaTokenHeader(ctx), &resp); err != nil {
+		return "", fmt.Errorf("get forgejo version: %w", err)
+	}
+	v, err := parseForgejoVersion(resp.Version)
+	if err != nil {
+		return "", err
+	}
+	slog.DebugContext(ctx, "detected forgejo version", "version", resp.Version, "gitea_compat", v)
+	forgejoVersions.byURL[GiteaURL] = v
+	return v, nil
+}
+
+// 创建 Forgejo 客户端（复用 Gitea SDK）
+func newForgejoClient(ctx context.Context) (*gitea.Client, error) {
+	v, err := forgejoGiteaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gitea.NewClient(GiteaURL,
+		gitea.SetContext(ctx),
+		gitea.SetToken(currentGiteaToken(ctx)),
+		gitea.SetHTTPClient(newHTTPClient()),
+		gitea.SetGiteaVersion(v),
+	)
+}
+
+// 从 Forgejo 获取目录下所有文件
+func fetchFilesFromForgejo(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "forgejo.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from forgejo",
+		"namespace", namespace, "repo", repo, "branch", branch, "path", path)
+
+	client, err := newForgejoClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create forgejo client", "error", err)
+		return nil, err
+	}
+	return listGiteaFiles(ctx, client, "forgejo", namespace, repo, branch, path)
+}
+
+func checkForgejo(ctx context.Context, namespace, repo string) error {
+	client, err := newForgejoClient(ctx)
+	if err != nil {
+		return fmt.Errorf("create forgejo client: %w", err)
+	}
+	if _, _, err := client.GetMyUserInfo(); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	if repo != "" {
+		if _, _, err := client.GetRepo(namespace, repo); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", namespace, repo, err)
+		}
+	}
+	return nil
+}
+
+// Gogs 0.12 起才提供 contents API，且没有 /version 接口，只能通过 SERVER_VERSION 声明
+var gogsMinVersion = version.Must(version.NewVersion("0.12.0"))
+
+func checkGogsVersion() error {
+	if ServerVersion == "" {
+		return nil
+	}
+	v, err := version.NewVersion(ServerVersion)
+	if err != nil {
+		return fmt.Errorf("invalid gogs version %q: %w", ServerVersion, err)
+	}
+	if v.LessThan(gogsMinVersion) {
+		return fmt.Errorf("gogs %s is not supported, contents API requires %s or later", v, gogsMinVersion)
+	}
+	return nil
+}
+
+// Gitea 系 API 的 "Authorization: token" 认证头
+func giteaTokenHeader(ctx context.Context) http.Header {
+	header := http.Header{}
+	if token := currentGiteaToken(ctx); token != "" {
+		header.Set("Authorization", "token "+token)
+	}
+	return header
+}
+
+// Gogs contents API 的目录条目
+type gogsContent struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Gogs API 路径，path 中的每一段单独转义
+func gogsAPIURL(format string, args ...string) string {
+	escaped := make([]any, len(args))
+	for i, arg := range args {
+		escaped[i] = escapePath(arg)
+	}
+	return strings.TrimSuffix(GiteaURL, "/") + "/api/v1" + fmt.Sprintf(format, escaped...)
+}
+
+// 从 Gogs 获取目录下所有文件
+func fetchFilesFromGogs(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "gogs.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from gogs",
+		"namespace", namespace, "repo", repo, "branch", branch, "path", path)
+
+	if err := checkGogsVersion(); err != nil {
+		return nil, err
+	}
+
+	// 获取目录内容列表
+	var contents []gogsContent
+	listCtx, listSpan := startSpan(ctx, "gogs.list_contents")
+	err = getJSON(listCtx, gogsAPIURL("/repos/%s/%s/contents/%s", namespace, repo, path)+"?ref="+url.QueryEscape(branch), giteaTokenHeader(ctx), &contents)
+	endSpan(listSpan, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get directory contents", "error", err)
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "found items in directory", "count", len(contents))
+
+	var result []GiteaFile
+	for _, content := range contents {
+		if content.Type != "file" || !isConfigFile(content.Name) {
+			slog.DebugContext(ctx, "skipping entry", "name", content.Name, "type", content.Type)
+			continue
+		}
+
+		// raw 接口直接返回文件内容，ref 在路径中
+		fileCtx, fileSpan := startSpan(ctx, "gogs.get_file", attribute.String("config.file", content.Path))
+		data, err := getRaw(fileCtx, gogsAPIURL("/repos/%s/%s/raw/%s/%s", namespace, repo, branch, content.Path), giteaTokenHeader(ctx))
+		endSpan(fileSpan, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to fetch file", "file", content.Path, "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    content.Name,
+			Path:    content.Path,
+			Type:    "file",
+			Content: string(data),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", content.Name, "bytes", len(data))
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
+	return result, nil
+}
+
+func checkGogs(ctx context.Context, namespace, repo string) error {
+	if err := checkGogsVersion(); err != nil {
+		return err
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := getJSON(ctx, gogsAPIURL("/user"), giteaTokenHeader(ctx), &user); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	if repo != "" {
+		var info struct {
+			FullName string `json:"full_name"`
+		}
+		if err := getJSON(ctx, gogsAPIURL("/repos/%s/%s", namespace, repo), giteaTokenHeader(ctx), &info); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", namespace, repo, err)
+		}
+	}
+	return nil
+}
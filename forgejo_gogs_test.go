@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 用录制的 API 响应模拟 Forgejo/Gogs 服务器，routes 为 URL path（含 query）到 testdata 文件的映射
+func newFixtureServer(t *testing.T, serverType, dir string, routes map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token test-token" {
+			http.Error(w, `{"message":"token is required"}`, http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Path
+		if r.URL.RawQuery != "" {
+			key += "?" + r.URL.RawQuery
+		}
+		fixture, ok := routes[key]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", dir, fixture))
+		if err != nil {
+			t.Errorf("read fixture: %v", err)
+		}
+		if strings.HasSuffix(fixture, ".json") {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	previous := []string{ServerType, GiteaURL, GiteaToken, ServerVersion}
+	ServerType, GiteaURL, GiteaToken, ServerVersion = serverType, srv.URL, "test-token", ""
+	t.Cleanup(func() {
+		ServerType, GiteaURL, GiteaToken, ServerVersion = previous[0], previous[1], previous[2], previous[3]
+	})
+	return srv
+}
+
+func TestParseForgejoVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{"7.0.5+gitea-1.21.11", "1.21.11", false},
+		{"v9.0.3+gitea-1.22.0", "1.22.0", false},
+		{"10.0.0-57-gd8c5de8+gitea-1.22.0", "1.22.0", false},
+		{"1.21.11-1", "1.21.11", false},
+		{"1.19.0-3", "1.19.0", false},
+		{"11.0.0", "", false},
+		{"", "", true},
+		{"development", "", true},
+		{"7.0.0+gitea-dev", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseForgejoVersion(tt.version)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseForgejoVersion(%q) = %q, %v; want %q, error %v", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFetchFilesFromForgejo(t *testing.T) {
+	newFixtureServer(t, "forgejo", "forgejo", map[string]string{
+		"/api/v1/version": "version.json",
+		"/api/v1/repos/team/woodpeckerfiles/contents/app/main?ref=main":      "contents.json",
+		"/api/v1/repos/team/woodpeckerfiles/raw/app/main/build.yml?ref=main": "build.yml",
+		"/api/v1/user":                       "user.json",
+		"/api/v1/repos/team/woodpeckerfiles": "repo.json",
+	})
+	forgejoVersions.byURL = make(map[string]string)
+
+	files, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "app/main/build.yml" || !strings.Contains(files[0].Content, "name: build") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if got := forgejoVersions.byURL[GiteaURL]; got != "1.21.11" {
+		t.Errorf("cached version = %q, want 1.21.11", got)
+	}
+
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+	if err := checkGitServer(context.Background(), "team", "missing"); err == nil {
+		t.Error("expected error for missing repo")
+	}
+}
+
+func TestFetchFilesFromForgejoLegacyRaw(t *testing.T) {
+	// Gitea 1.14 之前 raw 接口的 ref 在路径中，SERVER_VERSION 跳过 /version 探测
+	newFixtureServer(t, "forgejo", "forgejo", map[string]string{
+		"/api/v1/repos/team/woodpeckerfiles/contents/app/main?ref=main":  "contents.json",
+		"/api/v1/repos/team/woodpeckerfiles/raw/main/app/main/build.yml": "build.yml",
+	})
+	ServerVersion = "1.13.7-0"
+
+	files, err := fetchFilesFromForgejo(context.Background(), "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files = %+v, error = %v", files, err)
+	}
+}
+
+func TestFetchFilesFromGogs(t *testing.T) {
+	newFixtureServer(t, "gogs", "gogs", map[string]string{
+		"/api/v1/repos/team/woodpeckerfiles/contents/app/main?ref=release%2F1.0":  "contents.json",
+		"/api/v1/repos/team/woodpeckerfiles/raw/release/1.0/app/main/build.yml":   "build.yml",
+		"/api/v1/repos/team/woodpeckerfiles/raw/release/1.0/app/main/deploy.yaml": "deploy.yaml",
+		"/api/v1/user":                       "user.json",
+		"/api/v1/repos/team/woodpeckerfiles": "repo.json",
+	})
+
+	files, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "release/1.0", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "build.yml" || files[1].Name != "deploy.yaml" ||
+		!strings.Contains(files[1].Content, "name: deploy") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if _, err := fetchFilesFromGogs(context.Background(), "team", "woodpeckerfiles", "main", "app/missing"); err == nil {
+		t.Error("expected error for missing path")
+	}
+
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+	GiteaToken = "wrong"
+	if err := checkGitServer(context.Background(), "", ""); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("checkGitServer() error = %v, want 401", err)
+	}
+}
+
+func TestGogsVersion(t *testing.T) {
+	previous := ServerVersion
+	defer func() { ServerVersion = previous }()
+
+	for version, wantErr := range map[string]bool{"": false, "0.12.3": false, "0.13.0": false, "0.11.91": true, "bogus": true} {
+		ServerVersion = version
+		if err := checkGogsVersion(); (err != nil) != wantErr {
+			t.Errorf("version %q: error = %v, wantErr %v", version, err, wantErr)
+		}
+	}
+}
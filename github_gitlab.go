@@ -2,73 +2,94 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/google/go-github/v57/github"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// 从 GitHub 获取目录下所有文件
-func fetchFilesFromGitHub(namespace, repo, branch, path string) ([]GiteaFile, error) {
-	debugLog("fetchFilesFromGitHub - namespace: %s, repo: %s, branch: %s, path: %s",
-		namespace, repo, branch, path)
-
-	// 创建 HTTP 客户端（支持自签名证书）
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// 创建 GitHub 客户端：映射中的令牌优先，其次是 GitHub App 的安装令牌，最后使用 TOKEN
+func newGitHubClient(ctx context.Context, namespace string) (*github.Client, error) {
+	token := currentToken(ctx)
+	if githubAppEnabled() && mappedToken(ctx) == "" {
+		var err error
+		if token, err = githubApp.token(ctx, namespace); err != nil {
+			return nil, err
+		}
 	}
+	return githubClientWithToken(token)
+}
 
-	// 创建 GitHub 客户端
-	client := github.NewClient(httpClient).WithAuthToken(Token)
+// 非 api.github.com 时按 GitHub Enterprise 处理
+func githubClientWithToken(token string) (*github.Client, error) {
+	client := github.NewClient(newHTTPClient()).WithAuthToken(token)
 
 	// 如果是自托管 GitHub Enterprise，设置 BaseURL
 	if !strings.Contains(ServerURL, "api.github.com") {
-		var err error
-		client, err = client.WithEnterpriseURLs(ServerURL, ServerURL)
-		if err != nil {
-			debugLog("ERROR: Failed to set GitHub Enterprise URL: %v", err)
-			return nil, err
-		}
+		return client.WithEnterpriseURLs(ServerURL, ServerURL)
 	}
+	return client, nil
+}
+
+// 从 GitHub 获取目录下所有文件
+func fetchFilesFromGitHub(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "github.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from github",
+		"namespace", namespace, "repo", repo, "branch", branch, "path", path)
 
-	ctx := context.Background()
+	// 创建 GitHub 客户端
+	client, err := newGitHubClient(ctx, namespace)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to set github enterprise url", "error", err)
+		return nil, err
+	}
 
 	// 获取目录内容
-	_, directoryContent, _, err := client.Repositories.GetContents(ctx, namespace, repo, path, &github.RepositoryContentGetOptions{
+	listCtx, listSpan := startSpan(ctx, "github.list_contents")
+	_, directoryContent, resp, err := client.Repositories.GetContents(listCtx, namespace, repo, path, &github.RepositoryContentGetOptions{
 		Ref: branch,
 	})
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		err = notFoundError(err)
+	}
+	endSpan(listSpan, err)
 	if err != nil {
-		debugLog("ERROR: Failed to get directory contents: %v", err)
+		slog.ErrorContext(ctx, "failed to get directory contents", "error", err)
 		return nil, err
 	}
 
-	debugLog("Found %d items in directory", len(directoryContent))
+	slog.DebugContext(ctx, "found items in directory", "count", len(directoryContent))
 
 	// 处理每个文件
 	var result []GiteaFile
 	for _, content := range directoryContent {
-		// 只处理 .yml 和 .yaml 文件
-		if content.GetType() == "file" && (strings.HasSuffix(content.GetName(), ".yml") || strings.HasSuffix(content.GetName(), ".yaml")) {
-			debugLog("  Processing file: %s", content.GetName())
+		// 只处理配置文件（.yml/.yaml、.jsonnet/.libsonnet、.star、.cue）
+		if content.GetType() == "file" && isConfigFile(content.GetName()) {
+			slog.DebugContext(ctx, "processing file", "file", content.GetName())
 
 			// 获取文件内容
-			fileContent, _, _, err := client.Repositories.GetContents(ctx, namespace, repo, content.GetPath(), &github.RepositoryContentGetOptions{
+			fileCtx, fileSpan := startSpan(ctx, "github.get_file", attribute.String("config.file", content.GetPath()))
+			fileContent, _, _, err := client.Repositories.GetContents(fileCtx, namespace, repo, content.GetPath(), &github.RepositoryContentGetOptions{
 				Ref: branch,
 			})
+			endSpan(fileSpan, err)
 			if err != nil {
-				debugLog("    ERROR: Failed to fetch file: %v", err)
+				slog.ErrorContext(ctx, "failed to fetch file", "file", content.GetPath(), "error", err)
 				continue
 			}
 
 			// GitHub SDK 已经解码了 Base64
 			decodedContent, err := fileContent.GetContent()
 			if err != nil {
-				debugLog("    ERROR: Failed to get content: %v", err)
+				slog.ErrorContext(ctx, "failed to get content", "file", content.GetPath(), "error", err)
 				continue
 			}
 
@@ -79,98 +100,129 @@ func fetchFilesFromGitHub(namespace, repo, branch, path string) ([]GiteaFile, er
 				Content: decodedContent,
 			}
 
-			debugLog("    ✓ Loaded %s (%d bytes)", content.GetName(), len(giteaFile.Content))
+			slog.DebugContext(ctx, "loaded file", "file", content.GetName(), "bytes", len(giteaFile.Content))
 			result = append(result, giteaFile)
 		} else {
-			debugLog("  Skipping: %s (type: %s)", content.GetName(), content.GetType())
+			slog.DebugContext(ctx, "skipping entry", "name", content.GetName(), "type", content.GetType())
 		}
 	}
 
-	debugLog("Total files loaded: %d", len(result))
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
 	return result, nil
 }
 
-// 从 GitLab 获取目录下所有文件
-func fetchFilesFromGitLab(namespace, repo, branch, path string) ([]GiteaFile, error) {
-	debugLog("fetchFilesFromGitLab - namespace: %s, repo: %s, branch: %s, path: %s",
-		namespace, repo, branch, path)
-
-	// 创建 HTTP 客户端（支持自签名证书）
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+// 创建 GitLab 客户端，映射中配置了 OAuth 令牌时使用 Bearer 认证
+func newGitLabClient(ctx context.Context) (*gitlab.Client, error) {
+	options := []gitlab.ClientOptionFunc{
+		gitlab.WithBaseURL(ServerURL),
+		gitlab.WithHTTPClient(newHTTPClient()),
 	}
+	if c := credentialFromContext(ctx); c != nil {
+		if token := c.gitlabOAuthToken(); token != "" {
+			return gitlab.NewOAuthClient(token, options...)
+		}
+	}
+	return gitlab.NewClient(currentToken(ctx), options...)
+}
+
+// GitLab 项目 ID：namespace 可以是多级子组（group/subgroup），
+// namespace 为空时 repo 可以是完整路径或数字 ID
+func gitlabProjectID(namespace, repo string) any {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		if id, err := strconv.Atoi(repo); err == nil {
+			return id
+		}
+		return strings.Trim(repo, "/")
+	}
+	return namespace + "/" + repo
+}
+
+// 从 GitLab 获取目录下所有文件
+func fetchFilesFromGitLab(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "gitlab.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from gitlab",
+		"namespace", namespace, "repo", repo, "branch", branch, "path", path)
 
 	// 创建 GitLab 客户端
-	client, err := gitlab.NewClient(Token,
-		gitlab.WithBaseURL(ServerURL),
-		gitlab.WithHTTPClient(httpClient),
-	)
+	client, err := newGitLabClient(ctx)
 	if err != nil {
-		debugLog("ERROR: Failed to create GitLab client: %v", err)
+		slog.ErrorContext(ctx, "failed to create gitlab client", "error", err)
 		return nil, err
 	}
 
-	// GitLab 项目 ID（格式：namespace/repo）
-	projectID := namespace + "/" + repo
+	projectID := gitlabProjectID(namespace, repo)
 
-	// 列出目录树
+	// 列出目录树，按 keyset（服务器不支持时退回 offset）翻页直到最后一页
 	treeOptions := &gitlab.ListTreeOptions{
-		Path: &path,
-		Ref:  &branch,
+		Path:      &path,
+		Ref:       &branch,
+		Recursive: gitlab.Ptr(GitLabRecursive),
 		ListOptions: gitlab.ListOptions{
-			PerPage: 100,
+			Pagination: "keyset",
+			PerPage:    100,
 		},
 	}
 
-	trees, _, err := client.Repositories.ListTree(projectID, treeOptions)
+	listCtx, listSpan := startSpan(ctx, "gitlab.list_tree")
+	trees, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.TreeNode, *gitlab.Response, error) {
+		return client.Repositories.ListTree(projectID, treeOptions, gitlab.WithContext(listCtx), p)
+	})
+	if errors.Is(err, gitlab.ErrNotFound) {
+		err = notFoundError(err)
+	}
+	endSpan(listSpan, err)
 	if err != nil {
-		debugLog("ERROR: Failed to list tree: %v", err)
+		slog.ErrorContext(ctx, "failed to list tree", "error", err)
 		return nil, err
 	}
 
-	debugLog("Found %d items in directory", len(trees))
+	slog.DebugContext(ctx, "found items in directory", "count", len(trees))
 
 	// 处理每个文件
 	var result []GiteaFile
 	for _, tree := range trees {
-		// 只处理 .yml 和 .yaml 文件
-		if tree.Type == "blob" && (strings.HasSuffix(tree.Name, ".yml") || strings.HasSuffix(tree.Name, ".yaml")) {
-			debugLog("  Processing file: %s", tree.Name)
+		// 只处理配置文件（.yml/.yaml、.jsonnet/.libsonnet、.star、.cue）
+		if tree.Type == "blob" && isConfigFile(tree.Name) {
+			slog.DebugContext(ctx, "processing file", "file", tree.Name)
 
 			// 获取文件内容
 			fileOptions := &gitlab.GetFileOptions{
 				Ref: &branch,
 			}
 
-			file, _, err := client.RepositoryFiles.GetFile(projectID, tree.Path, fileOptions)
+			fileCtx, fileSpan := startSpan(ctx, "gitlab.get_file", attribute.String("config.file", tree.Path))
+			file, _, err := client.RepositoryFiles.GetFile(projectID, tree.Path, fileOptions, gitlab.WithContext(fileCtx))
+			endSpan(fileSpan, err)
 			if err != nil {
-				debugLog("    ERROR: Failed to fetch file: %v", err)
+				slog.ErrorContext(ctx, "failed to fetch file", "file", tree.Path, "error", err)
 				continue
 			}
 
 			// GitLab SDK 返回 Base64 编码的内容，需要解码
 			decodedContent, err := base64.StdEncoding.DecodeString(file.Content)
 			if err != nil {
-				debugLog("    ERROR: Failed to decode base64: %v", err)
+				slog.ErrorContext(ctx, "failed to decode base64", "file", tree.Path, "error", err)
 				continue
 			}
 
+			// 递归列出时用相对路径作为文件名，避免子目录中的同名文件冲突
 			giteaFile := GiteaFile{
-				Name:    tree.Name,
+				Name:    strings.TrimPrefix(tree.Path, strings.Trim(path, "/")+"/"),
 				Path:    tree.Path,
 				Type:    "file",
 				Content: string(decodedContent),
 			}
 
-			debugLog("    ✓ Loaded %s (%d bytes)", tree.Name, len(giteaFile.Content))
+			slog.DebugContext(ctx, "loaded file", "file", tree.Name, "bytes", len(giteaFile.Content))
 			result = append(result, giteaFile)
 		} else {
-			debugLog("  Skipping: %s (type: %s)", tree.Name, tree.Type)
+			slog.DebugContext(ctx, "skipping entry", "name", tree.Name, "type", tree.Type)
 		}
 	}
 
-	debugLog("Total files loaded: %d", len(result))
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
 	return result, nil
 }
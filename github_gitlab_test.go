@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 模拟 GitLab tree/files API：每页 2 条，keyset 为 true 时返回 Link 头，否则用 X-Next-Page 做 offset 分页；
+// 返回请求中出现过的项目 ID（URL 编码后的路径段）
+func newFakeGitLab(t *testing.T, keyset bool, files map[string]string, dirs ...string) *[]string {
+	t.Helper()
+	var projects []string
+
+	type node struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	var all []node
+	for _, dir := range dirs {
+		all = append(all, node{Name: path.Base(dir), Path: dir, Type: "tree"})
+	}
+	for name := range files {
+		all = append(all, node{Name: path.Base(name), Path: name, Type: "blob"})
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != "test-token" {
+			http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		p := r.URL.EscapedPath()
+		q := r.URL.Query()
+		if project, _, ok := strings.Cut(strings.TrimPrefix(p, "/api/v4/projects/"), "/"); ok {
+			projects = append(projects, project)
+		}
+		switch {
+		case strings.HasSuffix(p, "/repository/tree"):
+			dir, recursive := q.Get("path"), q.Get("recursive") == "true"
+			var matched []node
+			for _, n := range all {
+				if path.Dir(n.Path) == dir || (recursive && strings.HasPrefix(n.Path, dir+"/")) {
+					matched = append(matched, n)
+				}
+			}
+			// 按路径排序保证分页稳定
+			sort.Slice(matched, func(i, j int) bool { return matched[i].Path < matched[j].Path })
+
+			start := 0
+			if keyset {
+				if q.Get("pagination") != "keyset" {
+					t.Errorf("expected keyset pagination, got query %s", r.URL.RawQuery)
+				}
+				for i, n := range matched {
+					if n.Path == q.Get("page_token") {
+						start = i + 1
+					}
+				}
+			} else if page, _ := strconv.Atoi(q.Get("page")); page > 1 {
+				start = (page - 1) * 2
+			}
+			end := min(start+2, len(matched))
+			if end < len(matched) {
+				if keyset {
+					next := url.Values{"pagination": {"keyset"}, "per_page": {"2"}, "page_token": {matched[end-1].Path},
+						"path": {dir}, "ref": {q.Get("ref")}, "recursive": {q.Get("recursive")}}
+					w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, server.URL, p, next.Encode()))
+				} else {
+					w.Header().Set("X-Next-Page", strconv.Itoa(end/2+1))
+				}
+			}
+			json.NewEncoder(w).Encode(matched[start:end])
+		case strings.Contains(p, "/repository/files/"):
+			name, _ := url.PathUnescape(p[strings.Index(p, "/repository/files/")+len("/repository/files/"):])
+			content, ok := files[name]
+			if !ok {
+				http.Error(w, `{"message":"404 File Not Found"}`, http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"file_name": path.Base(name),
+				"file_path": name,
+				"encoding":  "base64",
+				"content":   base64.StdEncoding.EncodeToString([]byte(content)),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	previous := []string{ServerType, ServerURL, Token}
+	previousRecursive := GitLabRecursive
+	ServerType, ServerURL, Token = "gitlab", server.URL, "test-token"
+	t.Cleanup(func() {
+		ServerType, ServerURL, Token = previous[0], previous[1], previous[2]
+		GitLabRecursive = previousRecursive
+	})
+	return &projects
+}
+
+func TestGitLabProjectID(t *testing.T) {
+	tests := []struct {
+		namespace, repo string
+		want            any
+	}{
+		{"team", "woodpeckerfiles", "team/woodpeckerfiles"},
+		{"group/subgroup", "woodpeckerfiles", "group/subgroup/woodpeckerfiles"},
+		{"", "42", 42},
+		{"", "group/subgroup/woodpeckerfiles", "group/subgroup/woodpeckerfiles"},
+		{"team", "42", "team/42"},
+	}
+	for _, tt := range tests {
+		if got := gitlabProjectID(tt.namespace, tt.repo); got != tt.want {
+			t.Errorf("gitlabProjectID(%q, %q) = %v, want %v", tt.namespace, tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestFetchFilesFromGitLabPagination(t *testing.T) {
+	files := map[string]string{}
+	for i := range 5 {
+		files[fmt.Sprintf("app/main/step%d.yml", i)] = fmt.Sprintf("steps:\n  - name: s%d\n    image: alpine\n", i)
+	}
+	files["app/main/nested/lint.yml"] = "steps:\n  - name: lint\n    image: alpine\n"
+
+	for _, keyset := range []bool{true, false} {
+		t.Run(fmt.Sprintf("keyset=%v", keyset), func(t *testing.T) {
+			newFakeGitLab(t, keyset, files, "app/main/nested")
+
+			// 嵌套子组
+			got, err := fetchFilesFromGitServer(context.Background(), "group/subgroup", "woodpeckerfiles", "main", "app/main")
+			if err != nil {
+				t.Fatalf("fetch error = %v", err)
+			}
+			if len(got) != 5 {
+				t.Fatalf("got %d files, want 5 across all pages", len(got))
+			}
+
+			// 递归列出子目录，数字项目 ID
+			GitLabRecursive = true
+			got, err = fetchFilesFromGitServer(context.Background(), "", "42", "main", "app/main")
+			if err != nil {
+				t.Fatalf("recursive fetch error = %v", err)
+			}
+			if len(got) != 6 || got[0].Name != "nested/lint.yml" || !strings.Contains(got[0].Content, "name: lint") {
+				t.Fatalf("unexpected recursive files: %d %+v", len(got), got[0])
+			}
+		})
+	}
+}
+
+func TestFetchFilesFromGitLabEmptyNamespace(t *testing.T) {
+	files := map[string]string{"app/main/build.yml": "steps:\n  - name: build\n    image: alpine\n"}
+	projects := newFakeGitLab(t, true, files)
+
+	// namespace 为空时 reponame 可以是数字 ID 或完整路径
+	for _, tt := range []struct{ repo, project string }{
+		{"42", "42"},
+		{"group/subgroup/woodpeckerfiles", "group%2Fsubgroup%2Fwoodpeckerfiles"},
+	} {
+		*projects = nil
+		got, err := fetchFilesFromGitLab(context.Background(), "", tt.repo, "main", "app/main")
+		if err != nil || len(got) != 1 {
+			t.Fatalf("fetch %s: files = %+v, error = %v", tt.repo, got, err)
+		}
+		for _, p := range *projects {
+			if p != tt.project {
+				t.Errorf("fetch %s requested project %q, want %q", tt.repo, p, tt.project)
+			}
+		}
+	}
+}
+
+func TestNamespaceTemplateExplicitlyEmpty(t *testing.T) {
+	t.Setenv("WOODPECKER_CONFIG_NAMESPACE_TEMP", "")
+	if got := lookupEnvWithFallback("WOODPECKER_CONFIG_NAMESPACE_TEMP", "DRONE_CONFIG_NAMESPACE_TEMP", "{{ .Repo.Owner }}"); got != "" {
+		t.Errorf("explicitly empty namespace template = %q, want empty", got)
+	}
+}
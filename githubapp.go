@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// 安装令牌有效期 1 小时，提前刷新避免请求中途过期
+const githubAppTokenRefreshWindow = 5 * time.Minute
+
+// GitHub App 是否已配置
+func githubAppEnabled() bool {
+	return GitHubAppID != "" && (GitHubAppPrivateKey != "" || GitHubAppPrivateKeyFile != "")
+}
+
+type githubInstallationToken struct {
+	installationID int64
+	token          string
+	expiresAt      time.Time
+}
+
+// 按 namespace（组织或用户）缓存安装 ID 与安装令牌
+type githubAppTokens struct {
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	keyPEM string
+	tokens map[string]*githubInstallationToken
+	now    func() time.Time
+
+	// 上一次签发的 JWT，未临近过期且私钥未变时复用
+	jwtToken     string
+	jwtKey       *rsa.PrivateKey
+	jwtExpiresAt time.Time
+}
+
+var githubApp = &githubAppTokens{tokens: make(map[string]*githubInstallationToken), now: time.Now}
+
+// 读取 PEM 私钥，支持 PKCS#1 与 PKCS#8
+func parseGitHubAppKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("github app private key: no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("github app private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key: not an RSA key")
+	}
+	return key, nil
+}
+
+// 私钥文件与其他凭据文件一样在变化后重新读取，内容变化时才重新解析
+func (a *githubAppTokens) privateKey() (*rsa.PrivateKey, error) {
+	// 环境变量中的 PEM 常被写成一行，把字面量 \n 还原为换行
+	data := strings.ReplaceAll(GitHubAppPrivateKey, `\n`, "\n")
+	if githubAppKeyFile.path != "" {
+		data = githubAppKeyFile.get()
+	}
+	if a.key != nil && data == a.keyPEM {
+		return a.key, nil
+	}
+	key, err := parseGitHubAppKey([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	a.key, a.keyPEM = key, data
+	return key, nil
+}
+
+// 签发 App JWT（RS256），iat 回拨 60 秒以容忍时钟偏差，有效期不超过 GitHub 允许的 10 分钟
+func (a *githubAppTokens) jwt() (string, error) {
+	key, err := a.privateKey()
+	if err != nil {
+		return "", err
+	}
+	now := a.now()
+	if a.jwtToken != "" && key == a.jwtKey && now.Before(a.jwtExpiresAt.Add(-time.Minute)) {
+		return a.jwtToken, nil
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": GitHubAppID,
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign github app jwt: %w", err)
+	}
+	signed := signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	// JWT 可能出现在 go-github 的错误信息和 debug 日志中
+	unregisterSecret(a.jwtToken)
+	registerSecret(signed)
+	a.jwtToken, a.jwtKey, a.jwtExpiresAt = signed, key, now.Add(9*time.Minute)
+	return signed, nil
+}
+
+// 查找 namespace 对应的安装：GITHUB_APP_INSTALLATION_ID 优先，其次按组织、用户查找
+func findGitHubInstallation(ctx context.Context, client *github.Client, namespace string) (int64, error) {
+	if GitHubAppInstallationID != "" {
+		return strconv.ParseInt(GitHubAppInstallationID, 10, 64)
+	}
+	if namespace == "" {
+		return 0, errors.New("github app: namespace is required to discover the installation")
+	}
+
+	installation, resp, err := client.Apps.FindOrganizationInstallation(ctx, namespace)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, _, err = client.Apps.FindUserInstallation(ctx, namespace)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("github app is not installed for %s: %w", namespace, err)
+	}
+	return installation.GetID(), nil
+}
+
+// 获取 namespace 的安装令牌，缓存到临近过期；凭据映射可以为 namespace 指定安装 ID
+func (a *githubAppTokens) token(ctx context.Context, namespace string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var installationID int64
+	key := namespace
+	if c := credentialFromContext(ctx); c != nil && c.GitHubAppInstallationID != 0 {
+		installationID = c.GitHubAppInstallationID
+		key = "installation/" + strconv.FormatInt(installationID, 10)
+	}
+
+	cached := a.tokens[key]
+	if cached != nil && a.now().Before(cached.expiresAt.Add(-githubAppTokenRefreshWindow)) {
+		return cached.token, nil
+	}
+
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+	client, err := githubClientWithToken(jwt)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case cached != nil:
+		installationID = cached.installationID
+	case installationID == 0:
+		if installationID, err = findGitHubInstallation(ctx, client, namespace); err != nil {
+			return "", err
+		}
+	}
+
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("create installation token for %s: %w", namespace, err)
+	}
+	if cached != nil {
+		unregisterSecret(cached.token)
+	}
+	registerSecret(token.GetToken())
+	a.tokens[key] = &githubInstallationToken{
+		installationID: installationID,
+		token:          token.GetToken(),
+		expiresAt:      token.GetExpiresAt().Time,
+	}
+	slog.DebugContext(ctx, "refreshed github app installation token",
+		"namespace", namespace, "installation_id", installationID, "expires_at", token.GetExpiresAt().Time)
+	return token.GetToken(), nil
+}
+
+// 用 App JWT 验证 App 凭据是否有效（就绪检查用）
+func checkGitHubApp(ctx context.Context) error {
+	githubApp.mu.Lock()
+	jwt, err := githubApp.jwt()
+	githubApp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	client, err := githubClientWithToken(jwt)
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.Apps.Get(ctx, ""); err != nil {
+		return fmt.Errorf("github app auth check: %w", err)
+	}
+	return nil
+}
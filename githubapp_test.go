@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 校验 App JWT 的签名与 iss
+func verifyAppJWT(t *testing.T, pub *rsa.PublicKey, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if !ok || len(parts) != 3 {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+		return false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	json.Unmarshal(payload, &claims)
+	if claims.Iss != "12345" || claims.Exp-claims.Iat > 600 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	return true
+}
+
+func TestGitHubAppAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var lookups, issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/orgs/team/installation", "/api/v3/orgs/alice/installation",
+			"/api/v3/users/alice/installation", "/api/v3/app/installations/7/access_tokens",
+			"/api/v3/app/installations/8/access_tokens", "/api/v3/app":
+			if !verifyAppJWT(t, &key.PublicKey, r) {
+				http.Error(w, `{"message":"bad jwt"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+		switch r.URL.Path {
+		case "/api/v3/orgs/team/installation":
+			lookups.Add(1)
+			fmt.Fprint(w, `{"id":7}`)
+		case "/api/v3/users/alice/installation":
+			fmt.Fprint(w, `{"id":8}`)
+		case "/api/v3/app/installations/7/access_tokens", "/api/v3/app/installations/8/access_tokens":
+			n := issued.Add(1)
+			fmt.Fprintf(w, `{"token":"ghs_token%d","expires_at":%q}`, n, now.Add(time.Hour).Format(time.RFC3339))
+		case "/api/v3/app":
+			fmt.Fprint(w, `{"id":12345,"slug":"woodpecker-config"}`)
+		case "/api/v3/repos/team/woodpeckerfiles/contents/app/main":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ghs_token") {
+				http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `[{"type":"file","name":"build.yml","path":"app/main/build.yml"}]`)
+		case "/api/v3/repos/team/woodpeckerfiles/contents/app/main/build.yml":
+			fmt.Fprintf(w, `{"type":"file","encoding":"base64","content":%q}`,
+				base64.StdEncoding.EncodeToString([]byte("steps:\n  - name: build\n    image: alpine\n")))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous := []string{ServerType, ServerURL, Token, GitHubAppID, GitHubAppPrivateKey}
+	previousApp := githubApp
+	defer func() {
+		ServerType, ServerURL, Token, GitHubAppID, GitHubAppPrivateKey = previous[0], previous[1], previous[2], previous[3], previous[4]
+		githubApp = previousApp
+	}()
+	// 单行写法的 PEM 也能解析
+	ServerType, ServerURL, Token = "github", server.URL, ""
+	GitHubAppID, GitHubAppPrivateKey = "12345", strings.ReplaceAll(string(pemKey), "\n", `\n`)
+	githubApp = &githubAppTokens{tokens: make(map[string]*githubInstallationToken), now: func() time.Time { return now }}
+
+	ctx := context.Background()
+	files, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files = %+v, error = %v", files, err)
+	}
+
+	// 令牌在临近过期前复用
+	now = now.Add(50 * time.Minute)
+	if token, err := githubApp.token(ctx, "team"); err != nil || token != "ghs_token1" {
+		t.Errorf("token = %q, %v; want cached ghs_token1", token, err)
+	}
+
+	// 进入刷新窗口后重新签发，但不再查找安装
+	now = now.Add(6 * time.Minute)
+	if token, err := githubApp.token(ctx, "team"); err != nil || token != "ghs_token2" {
+		t.Errorf("token = %q, %v; want refreshed ghs_token2", token, err)
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("installation looked up %d times, want 1", n)
+	}
+
+	// 安装令牌和 JWT 登记为密钥，被替换的安装令牌移除
+	githubApp.mu.Lock()
+	jwt, err := githubApp.jwt()
+	githubApp.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"ghs_token2", jwt} {
+		if got := redactString("Bearer " + secret); strings.Contains(got, secret) {
+			t.Errorf("secret not redacted: %s", got)
+		}
+	}
+	if got := redactString("ghs_token1"); got != "ghs_token1" {
+		t.Errorf("replaced token still registered: %s", got)
+	}
+
+	// 组织不存在时按用户查找安装
+	if token, err := githubApp.token(ctx, "alice"); err != nil || token != "ghs_token3" {
+		t.Errorf("user installation token = %q, %v", token, err)
+	}
+	if _, err := githubApp.token(ctx, "nobody"); err == nil {
+		t.Error("expected error for namespace without installation")
+	}
+
+	// 凭据映射指定安装 ID 时不再查找
+	mapped := withCredential(ctx, &credential{Match: "nobody", GitHubAppInstallationID: 8})
+	if token, err := githubApp.token(mapped, "nobody"); err != nil || token != "ghs_token4" {
+		t.Errorf("mapped installation token = %q, %v", token, err)
+	}
+
+	if err := checkGitServer(ctx, "", ""); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+}
+
+func TestParseGitHubAppKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	if _, err := parseGitHubAppKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})); err != nil {
+		t.Errorf("PKCS#8 key: %v", err)
+	}
+	if _, err := parseGitHubAppKey([]byte("not a key")); err == nil {
+		t.Error("expected error for invalid PEM")
+	}
+}
+
+func TestGitHubAppKeyFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.pem")
+	writeKey := func(mtime time.Time) *rsa.PrivateKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err := os.WriteFile(path, pemKey, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	previous := githubAppKeyFile
+	githubAppKeyFile = &secretFile{name: "GITHUB_APP_PRIVATE_KEY_FILE", path: path}
+	defer func() { githubAppKeyFile = previous }()
+	app := &githubAppTokens{tokens: make(map[string]*githubInstallationToken), now: time.Now}
+
+	first := writeKey(time.Now())
+	if key, err := app.privateKey(); err != nil || !key.Equal(first) {
+		t.Fatalf("privateKey() = %v, want the first key", err)
+	}
+
+	// 轮换私钥文件后不需要重启
+	second := writeKey(time.Now().Add(time.Minute))
+	if key, err := app.privateKey(); err != nil || !key.Equal(second) {
+		t.Fatalf("privateKey() after rotation = %v, want the new key", err)
+	}
+}
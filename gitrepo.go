@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	stdssh "golang.org/x/crypto/ssh"
+)
+
+// GIT_URL_TEMPLATE 可用的变量
+type gitURLData struct {
+	ServerURL string
+	Namespace string
+	Repo      string
+}
+
+// 本地缓存的配置仓库镜像（bare mirror）
+type gitMirror struct {
+	mu        sync.RWMutex
+	url       string
+	dir       string
+	cred      *credential // 创建镜像时匹配的凭据，后台 fetch 时沿用
+	repo      *git.Repository
+	lastFetch time.Time
+}
+
+// 按 URL 管理所有镜像
+type gitMirrorSet struct {
+	mu      sync.Mutex
+	mirrors map[string]*gitMirror
+}
+
+var gitMirrors = &gitMirrorSet{mirrors: make(map[string]*gitMirror)}
+
+// 渲染配置仓库的 clone URL
+func gitRepoURL(namespace, repo string) (string, error) {
+	tmpl, err := template.New("git").Option("missingkey=error").Parse(GitURLTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parse GIT_URL_TEMPLATE: %w", err)
+	}
+	var buf bytes.Buffer
+	data := gitURLData{ServerURL: strings.TrimSuffix(ServerURL, "/"), Namespace: namespace, Repo: repo}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute GIT_URL_TEMPLATE: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// HTTPS 使用 token 作为密码，SSH 使用 deploy key
+func gitAuth(ctx context.Context, url string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		if GitSSHKeyFile == "" {
+			return nil, nil
+		}
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		auth, err := gitssh.NewPublicKeysFromFile(user, GitSSHKeyFile, GitSSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("load ssh key: %w", err)
+		}
+		if GitSSHInsecureIgnoreHostKey {
+			auth.HostKeyCallback = stdssh.InsecureIgnoreHostKey()
+		} else {
+			var files []string
+			if GitSSHKnownHosts != "" {
+				files = append(files, GitSSHKnownHosts)
+			}
+			callback, err := gitssh.NewKnownHostsCallback(files...)
+			if err != nil {
+				return nil, fmt.Errorf("load known_hosts: %w", err)
+			}
+			auth.HostKeyCallback = callback
+		}
+		return auth, nil
+	case "http", "https":
+		token := currentToken(ctx)
+		if token == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: GitUsername, Password: token}, nil
+	}
+	return nil, nil
+}
+
+// 获取（必要时创建）URL 对应的镜像
+func (s *gitMirrorSet) get(ctx context.Context, url string) (*gitMirror, error) {
+	s.mu.Lock()
+	m, ok := s.mirrors[url]
+	if !ok {
+		sum := sha256.Sum256([]byte(url))
+		m = &gitMirror{url: url, dir: filepath.Join(GitCacheDir, hex.EncodeToString(sum[:8])+".git"), cred: credentialFromContext(ctx)}
+		s.mirrors[url] = m
+	}
+	s.mu.Unlock()
+
+	if err := m.open(ctx); err != nil {
+		// clone 失败的镜像不保留，避免后台 fetch 处理没有仓库的镜像，也避免不存在的仓库累积
+		s.mu.Lock()
+		if s.mirrors[url] == m {
+			delete(s.mirrors, url)
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+	return m, nil
+}
+
+// 所有已知镜像
+func (s *gitMirrorSet) all() []*gitMirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*gitMirror, 0, len(s.mirrors))
+	for _, m := range s.mirrors {
+		list = append(list, m)
+	}
+	return list
+}
+
+// 打开已有的缓存，不存在时做一次 bare clone
+func (m *gitMirror) open(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.repo != nil {
+		return nil
+	}
+
+	if repo, err := git.PlainOpen(m.dir); err == nil {
+		m.repo = repo
+		slog.DebugContext(ctx, "opened cached git mirror", "url", m.url, "dir", m.dir)
+		// 远端不可达时与后台 fetch 一样继续使用已有的缓存
+		if err := m.fetchLocked(ctx); err != nil {
+			slog.WarnContext(ctx, "git fetch failed, serving cached mirror", "url", m.url, "error", err)
+		}
+		return nil
+	}
+
+	auth, err := gitAuth(withCredential(ctx, m.cred), m.url)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "cloning config repository", "url", m.url, "dir", m.dir)
+	os.RemoveAll(m.dir)
+	repo, err := git.PlainCloneContext(ctx, m.dir, true, &git.CloneOptions{
+		URL:    m.url,
+		Auth:   auth,
+		Mirror: true,
+	})
+	if err != nil {
+		os.RemoveAll(m.dir)
+		return fmt.Errorf("clone %s: %w", m.url, err)
+	}
+	m.repo = repo
+	m.lastFetch = time.Now()
+	return nil
+}
+
+// 从远端拉取最新的分支和 tag
+func (m *gitMirror) fetch(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 还没有 clone 成功（例如 clone 正在进行或刚刚失败）
+	if m.repo == nil {
+		return nil
+	}
+	return m.fetchLocked(ctx)
+}
+
+func (m *gitMirror) fetchLocked(ctx context.Context) error {
+	auth, err := gitAuth(withCredential(ctx, m.cred), m.url)
+	if err != nil {
+		return err
+	}
+
+	err = m.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs: []config.RefSpec{
+			"+refs/heads/*:refs/heads/*",
+			"+refs/tags/*:refs/tags/*",
+		},
+		Auth:  auth,
+		Force: true,
+		Prune: true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("fetch %s: %w", m.url, err)
+	}
+	m.lastFetch = time.Now()
+	slog.DebugContext(ctx, "fetched git mirror", "url", m.url, "up_to_date", err != nil)
+	return nil
+}
+
+// 把分支名、tag 或提交 SHA 解析为提交
+func (m *gitMirror) commit(ref string) (*object.Commit, error) {
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+		plumbing.ReferenceName(ref),
+	}
+	for _, name := range candidates {
+		if r, err := m.repo.Reference(name, true); err == nil {
+			return m.peel(r.Hash())
+		}
+	}
+	if plumbing.IsHash(ref) {
+		return m.peel(plumbing.NewHash(ref))
+	}
+	return nil, fmt.Errorf("reference %q not found", ref)
+}
+
+// annotated tag 需要剥离到提交
+func (m *gitMirror) peel(hash plumbing.Hash) (*object.Commit, error) {
+	if tag, err := m.repo.TagObject(hash); err == nil {
+		return tag.Commit()
+	}
+	return m.repo.CommitObject(hash)
+}
+
+// 从 git 对象中读取目录下的 pipeline 文件
+func (m *gitMirror) readDir(ctx context.Context, ref, path string) ([]GiteaFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	commit, err := m.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if path = strings.Trim(path, "/"); path != "" {
+		if tree, err = tree.Tree(path); err != nil {
+			err = fmt.Errorf("path %q at %s: %w", path, ref, err)
+			if errors.Is(err, object.ErrDirectoryNotFound) {
+				err = notFoundError(err)
+			}
+			return nil, err
+		}
+	}
+
+	var result []GiteaFile
+	for _, entry := range tree.Entries {
+		if !entry.Mode.IsFile() || !isConfigFile(entry.Name) {
+			slog.DebugContext(ctx, "skipping entry", "name", entry.Name, "mode", entry.Mode.String())
+			continue
+		}
+
+		blob, err := m.repo.BlobObject(entry.Hash)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read blob", "file", entry.Name, "error", err)
+			continue
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read blob", "file", entry.Name, "error", err)
+			continue
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read blob", "file", entry.Name, "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    entry.Name,
+			Path:    strings.TrimPrefix(path+"/"+entry.Name, "/"),
+			Type:    "file",
+			Content: string(content),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", entry.Name, "bytes", len(content))
+	}
+	return result, nil
+}
+
+// 从本地缓存的 git 镜像获取目录下所有文件
+func fetchFilesFromGit(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "git.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	url, err := gitRepoURL(namespace, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "fetch files from git mirror", "url", url, "branch", branch, "path", path)
+
+	mirror, err := gitMirrors.get(ctx, url)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to open git mirror", "url", url, "error", err)
+		return nil, err
+	}
+
+	files, err := mirror.readDir(ctx, branch, path)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read directory from git", "error", err)
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(files))
+	return files, nil
+}
+
+// 后台定期 fetch 所有镜像，ctx 取消时退出
+func startGitFetcher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fetchAllMirrors(ctx)
+			}
+		}
+	}()
+}
+
+func fetchAllMirrors(ctx context.Context) {
+	for _, m := range gitMirrors.all() {
+		fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		if err := m.fetch(fetchCtx); err != nil {
+			slog.WarnContext(ctx, "git fetch failed", "url", m.url, "error", err)
+		}
+		cancel()
+	}
+}
+
+// 就绪检查：能用当前凭据列出远端引用
+func checkGit(ctx context.Context, namespace, repo string) error {
+	if err := os.MkdirAll(GitCacheDir, 0o755); err != nil {
+		return fmt.Errorf("GIT_CACHE_DIR: %w", err)
+	}
+	if repo == "" {
+		return nil
+	}
+
+	url, err := gitRepoURL(namespace, repo)
+	if err != nil {
+		return err
+	}
+	auth, err := gitAuth(ctx, url)
+	if err != nil {
+		return err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{url}})
+	if _, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth}); err != nil {
+		return fmt.Errorf("list %s: %w", url, err)
+	}
+	return nil
+}
+
+// webhook：配置仓库有推送时立即 fetch，支持 GitHub/Gitea 签名和 GitLab token
+func handleGitWebhook(w http.ResponseWriter, r *http.Request) {
+	if currentWebhookSecret() == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifyWebhook(r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := withRequestID(context.WithoutCancel(r.Context()), requestIDFromHeader(r.Header.Get("X-Request-ID")))
+	slog.InfoContext(ctx, "git webhook received, fetching mirrors")
+	go fetchAllMirrors(ctx)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func verifyWebhook(header http.Header, body []byte) bool {
+	secret := currentWebhookSecret()
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+
+	signature := strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if signature == "" {
+		signature = header.Get("X-Gitea-Signature")
+	}
+	if signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// 本地测试用的配置仓库
+type testGitRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newTestGitRepo(t *testing.T, root, namespace, name string) *testGitRepo {
+	t.Helper()
+	dir := filepath.Join(root, namespace, name)
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testGitRepo{t: t, dir: dir, repo: repo}
+}
+
+// 写入文件并提交到指定分支
+func (r *testGitRepo) commit(branch string, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	for name, content := range files {
+		p := filepath.Join(r.dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	hash, err := wt.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash)); err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+func useGitBackend(t *testing.T, root string) {
+	t.Helper()
+	previous := []string{ServerType, ServerURL, GitURLTemplate, GitCacheDir}
+	previousMirrors := gitMirrors
+	ServerType, ServerURL = "git", root
+	GitURLTemplate = "file://{{ .ServerURL }}/{{ .Namespace }}/{{ .Repo }}"
+	GitCacheDir = t.TempDir()
+	gitMirrors = &gitMirrorSet{mirrors: make(map[string]*gitMirror)}
+	t.Cleanup(func() {
+		ServerType, ServerURL, GitURLTemplate, GitCacheDir = previous[0], previous[1], previous[2], previous[3]
+		gitMirrors = previousMirrors
+	})
+}
+
+func TestFetchFilesFromGit(t *testing.T) {
+	root := t.TempDir()
+	useGitBackend(t, root)
+
+	src := newTestGitRepo(t, root, "team", "woodpeckerfiles")
+	first := src.commit("main", map[string]string{
+		"app/main/build.yml": "steps:\n  - name: build\n    image: alpine\n",
+		"app/main/notes.md":  "ignored",
+	})
+	if _, err := src.repo.CreateTag("v1", first, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	files, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "app/main/build.yml" || !strings.Contains(files[0].Content, "image: alpine") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	// 新提交在 fetch 之前不可见
+	src.commit("main", map[string]string{"app/main/test.yml": "steps:\n  - name: test\n    image: alpine\n"})
+	files, _ = fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/main")
+	if len(files) != 1 {
+		t.Errorf("got %d files before fetch, want 1", len(files))
+	}
+
+	fetchAllMirrors(ctx)
+	files, _ = fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/main")
+	if len(files) != 2 {
+		t.Errorf("got %d files after fetch, want 2", len(files))
+	}
+
+	// tag 和提交 SHA 也可以作为 branch
+	for _, ref := range []string{"v1", first.String()} {
+		files, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", ref, "app/main")
+		if err != nil || len(files) != 1 {
+			t.Errorf("ref %s: files = %d, error = %v", ref, len(files), err)
+		}
+	}
+
+	if _, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "nope", "app/main"); err == nil {
+		t.Error("expected error for unknown branch")
+	}
+	if _, err := fetchFilesFromGitServer(ctx, "team", "woodpeckerfiles", "main", "app/missing"); err == nil {
+		t.Error("expected error for missing path")
+	}
+}
+
+func TestGitMirrorReusesCache(t *testing.T) {
+	root := t.TempDir()
+	useGitBackend(t, root)
+
+	src := newTestGitRepo(t, root, "team", "woodpeckerfiles")
+	src.commit("main", map[string]string{"ci/build.yml": "steps: {}\n"})
+
+	ctx := context.Background()
+	if _, err := fetchFilesFromGit(ctx, "team", "woodpeckerfiles", "main", "ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟进程重启：缓存目录已存在时直接打开并 fetch
+	gitMirrors = &gitMirrorSet{mirrors: make(map[string]*gitMirror)}
+	src.commit("develop", map[string]string{"ci/test.yml": "steps: {}\n"})
+
+	files, err := fetchFilesFromGit(ctx, "team", "woodpeckerfiles", "develop", "ci")
+	if err != nil || len(files) != 2 {
+		t.Errorf("files = %d, error = %v", len(files), err)
+	}
+
+	entries, _ := os.ReadDir(GitCacheDir)
+	if len(entries) != 1 {
+		t.Errorf("cache dir has %d entries, want 1", len(entries))
+	}
+
+	// 重启时远端不可达，第一个请求也使用已有的缓存
+	gitMirrors = &gitMirrorSet{mirrors: make(map[string]*gitMirror)}
+	if err := os.RemoveAll(src.dir); err != nil {
+		t.Fatal(err)
+	}
+	files, err = fetchFilesFromGit(ctx, "team", "woodpeckerfiles", "develop", "ci")
+	if err != nil || len(files) != 2 {
+		t.Errorf("stale cache: files = %d, error = %v", len(files), err)
+	}
+}
+
+func TestGitMirrorCloneFailure(t *testing.T) {
+	root := t.TempDir()
+	useGitBackend(t, root)
+
+	// 配置仓库不存在时 clone 失败，镜像不留在集合中，后台 fetch 不会处理它
+	ctx := context.Background()
+	if _, err := fetchFilesFromGit(ctx, "nobody", "woodpeckerfiles", "main", "ci"); err == nil {
+		t.Fatal("expected clone error for missing repository")
+	}
+	if n := len(gitMirrors.all()); n != 0 {
+		t.Errorf("got %d mirrors after failed clone, want 0", n)
+	}
+	fetchAllMirrors(ctx)
+
+	// 没有仓库的镜像直接跳过
+	if err := (&gitMirror{url: "file:///missing"}).fetch(ctx); err != nil {
+		t.Errorf("fetch() on uncloned mirror = %v", err)
+	}
+}
+
+func TestCheckGit(t *testing.T) {
+	root := t.TempDir()
+	useGitBackend(t, root)
+	newTestGitRepo(t, root, "team", "woodpeckerfiles").commit("main", map[string]string{"a.yml": "steps: {}\n"})
+
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+	if err := checkGitServer(context.Background(), "team", "missing"); err == nil {
+		t.Error("expected error for missing repo")
+	}
+}
+
+func TestGitWebhook(t *testing.T) {
+	previous := GitWebhookSecret
+	defer func() { GitWebhookSecret = previous }()
+
+	body := `{"ref":"refs/heads/main"}`
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name       string
+		secret     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"disabled", "", nil, http.StatusNotFound},
+		{"missing signature", "hook-secret", nil, http.StatusUnauthorized},
+		{"github signature", "hook-secret", map[string]string{"X-Hub-Signature-256": "sha256=" + signature}, http.StatusAccepted},
+		{"gitea signature", "hook-secret", map[string]string{"X-Gitea-Signature": signature}, http.StatusAccepted},
+		{"bad signature", "hook-secret", map[string]string{"X-Gitea-Signature": "deadbeef"}, http.StatusUnauthorized},
+		{"gitlab token", "hook-secret", map[string]string{"X-Gitlab-Token": "hook-secret"}, http.StatusAccepted},
+		{"bad gitlab token", "hook-secret", map[string]string{"X-Gitlab-Token": "nope"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			GitWebhookSecret = tt.secret
+			req := httptest.NewRequest(http.MethodPost, "/hooks/git", strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newServeMux().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// 单个后端的就绪检查
+type readinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// 就绪检查结果
+type checkResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type readinessReport struct {
+	Status    string        `json:"status"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Checks    []checkResult `json:"checks"`
+}
+
+// 缓存就绪检查结果，避免每次探针都请求 Git 服务器
+type readinessCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	report *readinessReport
+	checks func() []readinessCheck
+}
+
+var readiness = &readinessCache{checks: configuredBackends}
+
+// 当前配置的所有后端检查
+func configuredBackends() []readinessCheck {
+	namespace, repo := readinessRepo()
+	return []readinessCheck{{
+		Name: strings.ToLower(ServerType),
+		Check: func(ctx context.Context) error {
+			return checkGitServer(ctx, namespace, repo)
+		},
+	}}
+}
+
+// 就绪检查使用的配置仓库：优先 READINESS_REPO，其次是不含模板变量的 namespace/reponame 模板
+func readinessRepo() (namespace, repo string) {
+	if ReadinessRepo != "" {
+		namespace, repo, _ = strings.Cut(ReadinessRepo, "/")
+		return namespace, repo
+	}
+	if !strings.Contains(NamespaceTemplate, "{{") && !strings.Contains(RepoNameTemplate, "{{") {
+		return NamespaceTemplate, RepoNameTemplate
+	}
+	return "", ""
+}
+
+// 执行（或复用缓存的）就绪检查
+func (c *readinessCache) get(ctx context.Context) *readinessReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.ttl
+	if ttl == 0 {
+		ttl = ReadinessCacheTTL
+	}
+	if c.report != nil && time.Since(c.report.CheckedAt) < ttl {
+		return c.report
+	}
+
+	report := &readinessReport{Status: "ready", CheckedAt: time.Now()}
+	for _, check := range c.checks() {
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		start := time.Now()
+		err := check.Check(checkCtx)
+		cancel()
+
+		result := checkResult{
+			Name:       check.Name,
+			Status:     "ok",
+			DurationMS: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Status = "error"
+			result.Error = redactString(err.Error())
+			report.Status = "not ready"
+			slog.WarnContext(ctx, "readiness check failed", "backend", check.Name, "error", err)
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	c.report = report
+	return report
+}
+
+// 检查 Git 服务器的认证以及配置仓库是否可访问
+func checkGitServer(ctx context.Context, namespace, repo string) error {
+	ctx = withCredentials(ctx, namespace, repo)
+	switch strings.ToLower(ServerType) {
+	case "gitea":
+		return checkGitea(ctx, namespace, repo)
+	case "github":
+		return checkGitHub(ctx, namespace, repo)
+	case "gitlab":
+		return checkGitLab(ctx, namespace, repo)
+	case "file":
+		return checkDir(ctx)
+	case "git":
+		return checkGit(ctx, namespace, repo)
+	case "forgejo":
+		return checkForgejo(ctx, namespace, repo)
+	case "gogs":
+		return checkGogs(ctx, namespace, repo)
+	case "bitbucket":
+		return checkBitbucket(ctx, namespace, repo)
+	case "bitbucket-server":
+		return checkBitbucketServer(ctx, namespace, repo)
+	case "http":
+		return checkHTTP(ctx)
+	default:
+		return fmt.Errorf("unsupported server type: %s", ServerType)
+	}
+}
+
+func checkGitea(ctx context.Context, namespace, repo string) error {
+	client, err := newGiteaClient(ctx)
+	if err != nil {
+		return fmt.Errorf("create gitea client: %w", err)
+	}
+	if _, _, err := client.GetMyUserInfo(); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	if repo != "" {
+		if _, _, err := client.GetRepo(namespace, repo); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", namespace, repo, err)
+		}
+	}
+	return nil
+}
+
+func checkGitHub(ctx context.Context, namespace, repo string) error {
+	// GitHub App 没有对应用户，未指定仓库时验证 App 凭据
+	if githubAppEnabled() && repo == "" {
+		return checkGitHubApp(ctx)
+	}
+	client, err := newGitHubClient(ctx, namespace)
+	if err != nil {
+		return fmt.Errorf("create github client: %w", err)
+	}
+	if repo != "" {
+		if _, _, err := client.Repositories.Get(ctx, namespace, repo); err != nil {
+			return fmt.Errorf("config repo %s/%s: %w", namespace, repo, err)
+		}
+		return nil
+	}
+	// 没有指定仓库时，只验证 token 是否有效
+	if _, _, err := client.Users.Get(ctx, ""); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	return nil
+}
+
+func checkGitLab(ctx context.Context, namespace, repo string) error {
+	client, err := newGitLabClient(ctx)
+	if err != nil {
+		return fmt.Errorf("create gitlab client: %w", err)
+	}
+	if _, _, err := client.Users.CurrentUser(gitlab.WithContext(ctx)); err != nil {
+		return fmt.Errorf("auth check: %w", err)
+	}
+	if repo != "" {
+		projectID := gitlabProjectID(namespace, repo)
+		if _, _, err := client.Projects.GetProject(projectID, nil, gitlab.WithContext(ctx)); err != nil {
+			return fmt.Errorf("config repo %v: %w", projectID, err)
+		}
+	}
+	return nil
+}
+
+// 存活探针：进程能响应即可
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// 就绪探针：所有后端检查通过才返回 200
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := readiness.get(r.Context())
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// 管理接口：输出当前配置，需要 Authorization: Bearer $ADMIN_TOKEN
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	adminToken := currentAdminToken()
+	if adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version": serviceVersion,
+		"config": map[string]string{
+			"server_type":    ServerType,
+			"server_url":     ServerURL,
+			"namespace_tmpl": NamespaceTemplate,
+			"reponame_tmpl":  RepoNameTemplate,
+			"branch_tmpl":    BranchTemplate,
+			"path_tmpl":      PathTemplate,
+			"overlay_layers": OverlayLayers,
+			"debug":          fmt.Sprintf("%v", Debug),
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// HTTP_URL_TEMPLATE 可用的变量
+type httpLocation struct {
+	ServerURL string
+	Namespace string
+	Repo      string
+	Branch    string
+	Path      string
+}
+
+// 解析 HTTP_HEADERS，每行一个 "Name: value"，也可以用字面量 \n 分隔
+func parseHTTPHeaders(s string) (http.Header, error) {
+	header := http.Header{}
+	s = strings.ReplaceAll(s, `\n`, "\n")
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid HTTP_HEADERS entry %q, want \"Name: value\"", line)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// 请求配置源时附带的头，未显式配置 Authorization 时使用 TOKEN 作为 Bearer token
+func httpSourceHeader(ctx context.Context) (http.Header, error) {
+	header, err := parseHTTPHeaders(HTTPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if token := currentToken(ctx); token != "" && header.Get("Authorization") == "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header, nil
+}
+
+// 渲染索引 URL，拒绝包含 .. 的路径组件
+func httpIndexURL(namespace, repo, branch, dir string) (*url.URL, error) {
+	for _, part := range []string{namespace, repo, branch, dir} {
+		if strings.Contains(part, "..") {
+			return nil, fmt.Errorf("invalid path component %q", part)
+		}
+	}
+
+	tmpl, err := template.New("http").Option("missingkey=error").Parse(HTTPURLTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse HTTP_URL_TEMPLATE: %w", err)
+	}
+	var buf bytes.Buffer
+	data := httpLocation{
+		ServerURL: strings.TrimSuffix(ServerURL, "/"),
+		Namespace: namespace,
+		Repo:      repo,
+		Branch:    branch,
+		Path:      strings.Trim(dir, "/"),
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute HTTP_URL_TEMPLATE: %w", err)
+	}
+
+	u, err := url.Parse(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid index URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("index URL %q must be http or https", u.Redacted())
+	}
+	return u, nil
+}
+
+// JSON 清单支持三种格式：
+//
+//	["build.yml", "test.yml"]
+//	{"files": ["build.yml", "test.yml"]}
+//	[{"name": "build.yml", "type": "file"}]  （nginx autoindex_format json）
+func parseJSONManifest(data []byte) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+
+	var manifest struct {
+		Files []string `json:"files"`
+	}
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.Files != nil {
+		return manifest.Files, nil
+	}
+
+	var entries []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unsupported JSON manifest: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type == "" || entry.Type == "file" {
+			names = append(names, entry.Name)
+		}
+	}
+	return names, nil
+}
+
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+
+// 从 HTML 目录列表（nginx/Apache autoindex、python -m http.server 等）中提取链接
+func parseDirectoryListing(data []byte) []string {
+	var names []string
+	for _, match := range hrefPattern.FindAllSubmatch(data, -1) {
+		names = append(names, string(match[1]))
+	}
+	return names
+}
+
+// 根据 Content-Type 或内容判断索引格式，返回相对索引 URL 的文件链接
+func parseIndex(contentType string, data []byte) ([]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if strings.Contains(contentType, "json") || bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		return parseJSONManifest(trimmed)
+	}
+	return parseDirectoryListing(data), nil
+}
+
+// 从 HTTP 服务器（制品库、对象存储网关等）获取目录下所有文件
+func fetchFilesFromHTTP(ctx context.Context, namespace, repo, branch, dir string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "http.fetch_files", resolvedAttributes(namespace, repo, branch, dir)...)
+	defer func() { endSpan(span, err) }()
+
+	index, err := httpIndexURL(namespace, repo, branch, dir)
+	if err != nil {
+		return nil, err
+	}
+	header, err := httpSourceHeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "fetch files from http", "index", index.Redacted())
+
+	// 获取索引
+	listCtx, listSpan := startSpan(ctx, "http.get_index", attribute.String("http.url", index.Redacted()))
+	indexHeader := header.Clone()
+	indexHeader.Set("Accept", "application/json, text/html;q=0.9, */*;q=0.8")
+	contentType, data, err := httpGet(listCtx, index.String(), indexHeader)
+	var links []string
+	if err == nil {
+		links, err = parseIndex(contentType, data)
+	}
+	endSpan(listSpan, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get index", "index", index.Redacted(), "error", err)
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "found items in index", "count", len(links))
+
+	// 索引所在目录，文件链接必须位于该目录下
+	indexDir := path.Dir(index.ResolveReference(&url.URL{Path: "x"}).Path)
+
+	var result []GiteaFile
+	seen := make(map[string]bool)
+	for _, link := range links {
+		ref, err := url.Parse(link)
+		if err != nil || ref.Fragment != "" || ref.RawQuery != "" {
+			slog.DebugContext(ctx, "skipping entry", "name", link)
+			continue
+		}
+		fileURL := index.ResolveReference(ref)
+		name := path.Base(fileURL.Path)
+
+		// 只读取与索引同源、同一目录下的配置文件，避免把认证头发给其他主机
+		if fileURL.Scheme != index.Scheme || fileURL.Host != index.Host ||
+			path.Dir(fileURL.Path) != indexDir ||
+			!isConfigFile(name) || seen[name] {
+			slog.DebugContext(ctx, "skipping entry", "name", link)
+			continue
+		}
+		seen[name] = true
+
+		fileCtx, fileSpan := startSpan(ctx, "http.get_file", attribute.String("config.file", name))
+		content, err := getRaw(fileCtx, fileURL.String(), header)
+		endSpan(fileSpan, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to fetch file", "file", name, "error", err)
+			continue
+		}
+
+		result = append(result, GiteaFile{
+			Name:    name,
+			Path:    strings.TrimPrefix(strings.Trim(dir, "/")+"/"+name, "/"),
+			Type:    "file",
+			Content: string(content),
+		})
+		slog.DebugContext(ctx, "loaded file", "file", name, "bytes", len(content))
+	}
+
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
+	return result, nil
+}
+
+// 就绪检查：SERVER_URL 可访问且认证头被接受
+func checkHTTP(ctx context.Context) error {
+	header, err := httpSourceHeader(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ServerURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode >= 500 {
+		return fmt.Errorf("GET %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useHTTPSource(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	previous := []string{ServerType, ServerURL, Token, HTTPURLTemplate, HTTPHeaders}
+	ServerType, ServerURL, Token = "http", srv.URL, ""
+	HTTPURLTemplate = "{{ .ServerURL }}/{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}/"
+	HTTPHeaders = "X-Api-Key: test-key"
+	t.Cleanup(func() {
+		ServerType, ServerURL, Token, HTTPURLTemplate, HTTPHeaders = previous[0], previous[1], previous[2], previous[3], previous[4]
+	})
+	return srv
+}
+
+func TestParseIndex(t *testing.T) {
+	nginxHTML := `<html><head><title>Index of /app/main/</title></head><body>
+<h1>Index of /app/main/</h1><hr><pre><a href="../">../</a>
+<a href="shared/">shared/</a>                                            18-Oct-2026 10:00       -
+<a href="build.yml">build.yml</a>                                          18-Oct-2026 10:00      52
+<a href='test%20suite.yaml'>test suite.yaml</a>                            18-Oct-2026 10:00      52
+</pre><hr></body></html>`
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []string
+		wantErr     bool
+	}{
+		{"string list", "application/json", `["build.yml","test.yml"]`, []string{"build.yml", "test.yml"}, false},
+		{"files object", "", `{"files":["build.yml"]}`, []string{"build.yml"}, false},
+		{"nginx json", "application/json", `[{"name":"shared","type":"directory"},{"name":"build.yml","type":"file","size":52}]`, []string{"build.yml"}, false},
+		{"html listing", "text/html", nginxHTML, []string{"../", "shared/", "build.yml", "test%20suite.yaml"}, false},
+		{"invalid json", "application/json", `{"files":"build.yml"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIndex(tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parseIndex() = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseHTTPHeaders(t *testing.T) {
+	header, err := parseHTTPHeaders(`Authorization: Basic dXNlcjpwYXNz\nX-Api-Key: abc:def`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Basic dXNlcjpwYXNz" || header.Get("X-Api-Key") != "abc:def" {
+		t.Errorf("unexpected header: %v", header)
+	}
+	if _, err := parseHTTPHeaders("no-colon"); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+}
+
+func TestFetchFilesFromHTTPManifest(t *testing.T) {
+	var srv *httptest.Server
+	srv = useHTTPSource(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "test-key" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/team/woodpeckerfiles/main/app/main/":
+			w.Header().Set("Content-Type", "application/json")
+			// 清单中跳出目录或指向其他主机的条目会被忽略
+			fmt.Fprintf(w, `{"files":["build.yml","notes.md","../secret.yml","%s/team/other.yml","https://evil.example.com/x.yml","test.yaml"]}`, srv.URL)
+		case "/team/woodpeckerfiles/main/app/main/build.yml":
+			fmt.Fprint(w, "steps:\n  - name: build\n    image: alpine\n")
+		case "/team/woodpeckerfiles/main/app/main/test.yaml":
+			fmt.Fprint(w, "steps:\n  - name: test\n    image: alpine\n")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	files, err := fetchFilesFromGitServer(context.Background(), "team", "woodpeckerfiles", "main", "app/main")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Path != "app/main/build.yml" || files[1].Name != "test.yaml" ||
+		!strings.Contains(files[1].Content, "name: test") {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err != nil {
+		t.Errorf("checkGitServer() error = %v", err)
+	}
+	HTTPHeaders = "X-Api-Key: wrong"
+	if err := checkGitServer(context.Background(), "team", "woodpeckerfiles"); err == nil {
+		t.Error("expected error for rejected credentials")
+	}
+}
+
+func TestFetchFilesFromHTTPDirectoryListing(t *testing.T) {
+	useHTTPSource(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/configs/app/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<pre><a href="../">../</a><a href="build.yml">build.yml</a><a href="/configs/app/deploy.yml">deploy.yml</a><a href="?C=N;O=D">Name</a></pre>`)
+		case "/configs/app/build.yml":
+			fmt.Fprint(w, "steps:\n  - name: build\n    image: alpine\n")
+		case "/configs/app/deploy.yml":
+			fmt.Fprint(w, "steps:\n  - name: deploy\n    image: alpine\n")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	HTTPURLTemplate = "{{ .ServerURL }}/configs/{{ .Path }}/"
+	HTTPHeaders, Token = "", "test-token"
+
+	files, err := fetchFilesFromHTTP(context.Background(), "team", "woodpeckerfiles", "main", "app")
+	if err != nil {
+		t.Fatalf("fetch error = %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "build.yml" || files[1].Name != "deploy.yml" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	if _, err := fetchFilesFromHTTP(context.Background(), "team", "woodpeckerfiles", "main", "missing"); err == nil {
+		t.Error("expected error for missing index")
+	}
+	if _, err := fetchFilesFromHTTP(context.Background(), "team", "woodpeckerfiles", "main", "../etc"); err == nil ||
+		!strings.Contains(err.Error(), "invalid path component") {
+		t.Errorf("error = %v, want traversal rejection", err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 就地包含另一个文件：steps 列表中的 `- !include shared/lint.yml`，或映射值 `db: !include shared/db.yml`
+const includeTag = "!include"
+
+// 顶层 include 键：把其他文件整个合并进来
+const includeKey = "include"
+
+// include 解析器，loader 按仓库内路径读取文件；同一次解析中读取过的文件会被缓存
+type includeResolver struct {
+	load     func(p string) (GiteaFile, error)
+	render   func(file GiteaFile) (string, error)
+	maxDepth int
+	files    map[string]GiteaFile
+}
+
+func newIncludeResolver(load func(p string) (GiteaFile, error), render func(file GiteaFile) (string, error)) *includeResolver {
+	return &includeResolver{load: load, render: render, maxDepth: IncludeMaxDepth, files: make(map[string]GiteaFile)}
+}
+
+// 从配置仓库读取被包含的文件：按目录列出后取出对应文件，目录列表在本次请求内缓存
+func remoteIncludeLoader(ctx context.Context, namespace, repo, branch string) func(p string) (GiteaFile, error) {
+	dirs := make(map[string][]GiteaFile)
+	return func(p string) (GiteaFile, error) {
+		dir := path.Dir(p)
+		if dir == "." {
+			dir = ""
+		}
+		files, ok := dirs[dir]
+		if !ok {
+			var err error
+			if files, err = fetchFilesFromGitServer(ctx, namespace, repo, branch, dir); err != nil {
+				return GiteaFile{}, err
+			}
+			dirs[dir] = files
+		}
+		for _, f := range files {
+			if f.Path == p || path.Join(dir, f.Name) == p {
+				return f, nil
+			}
+		}
+		return GiteaFile{}, fmt.Errorf("file not found")
+	}
+}
+
+// 从本地目录读取被包含的文件，用于 validate 子命令
+func localIncludeLoader(root string) func(p string) (GiteaFile, error) {
+	return func(p string) (GiteaFile, error) {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(p)))
+		if err != nil {
+			return GiteaFile{}, err
+		}
+		return GiteaFile{Name: path.Base(p), Path: p, Type: "file", Content: string(data)}, nil
+	}
+}
+
+// 文件中是否可能有 include，避免无谓地重新序列化
+func mayInclude(content string) bool {
+	return strings.Contains(content, includeTag) || strings.Contains(content, includeKey+":")
+}
+
+// 展开文件中的 include；没有 include 时原样返回内容
+func (r *includeResolver) expand(file GiteaFile) (string, error) {
+	if !mayInclude(file.Content) {
+		return file.Content, nil
+	}
+	docs, err := decodeYAMLDocuments(file.Content)
+	if err != nil {
+		// 语法错误交给后面的 YAML 校验报告
+		return file.Content, nil
+	}
+
+	found := false
+	for _, doc := range docs {
+		if hasInclude(doc) {
+			found = true
+			if err := r.resolve(doc, file.Path, []string{file.Path}); err != nil {
+				return "", err
+			}
+		}
+	}
+	if !found {
+		return file.Content, nil
+	}
+	return encodeYAMLDocuments(docs)
+}
+
+// 展开 node 中的 include，stack 为当前的包含链，用于检测循环
+func (r *includeResolver) resolve(node *yaml.Node, from string, stack []string) error {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 && node.Content[0].Kind == yaml.MappingNode {
+		if err := r.resolveTopLevel(node.Content[0], from, stack); err != nil {
+			return err
+		}
+	}
+
+	var content []*yaml.Node
+	for i, child := range node.Content {
+		// 映射的 key 不做处理
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			content = append(content, child)
+			continue
+		}
+		if child.Kind == yaml.ScalarNode && child.Tag == includeTag {
+			included, err := r.include(child.Value, from, stack)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", child.Line, err)
+			}
+			// 列表中包含一个列表时展开到当前列表
+			if node.Kind == yaml.SequenceNode && included.Kind == yaml.SequenceNode {
+				content = append(content, included.Content...)
+			} else {
+				content = append(content, included)
+			}
+			continue
+		}
+		if err := r.resolve(child, from, stack); err != nil {
+			return err
+		}
+		content = append(content, child)
+	}
+	node.Content = content
+	return nil
+}
+
+// 处理顶层 include 键：按顺序合并被包含的文件，当前文件的内容最后合并
+func (r *includeResolver) resolveTopLevel(root *yaml.Node, from string, stack []string) error {
+	var targets *yaml.Node
+	var rest []*yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == includeKey {
+			targets = root.Content[i+1]
+			continue
+		}
+		rest = append(rest, root.Content[i], root.Content[i+1])
+	}
+	if targets == nil {
+		return nil
+	}
+
+	var paths []*yaml.Node
+	switch targets.Kind {
+	case yaml.ScalarNode:
+		paths = []*yaml.Node{targets}
+	case yaml.SequenceNode:
+		paths = targets.Content
+	default:
+		return fmt.Errorf("line %d: include must be a path or a list of paths", targets.Line)
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, p := range paths {
+		if p.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: include must be a path or a list of paths", p.Line)
+		}
+		included, err := r.include(p.Value, from, stack)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", p.Line, err)
+		}
+		if included.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: included file %s must be a mapping", p.Line, p.Value)
+		}
+		mergeIncluded(merged, included)
+	}
+	mergeIncluded(merged, &yaml.Node{Kind: yaml.MappingNode, Content: rest})
+	root.Content = merged.Content
+	return nil
+}
+
+// 合并顶层键：列表按顺序拼接，映射逐个覆盖 key，其他值由后者覆盖
+func mergeIncluded(base, overlay *yaml.Node) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		existing := mappingNode(base, key.Value)
+		switch {
+		case existing == nil:
+			base.Content = append(base.Content, key, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				setMappingValue(existing, value.Content[j], value.Content[j+1])
+			}
+		default:
+			setMappingValue(base, key, value)
+		}
+	}
+}
+
+func setMappingValue(node, key, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key.Value {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, key, value)
+}
+
+// 读取并展开一个被包含的文件，返回其根节点
+func (r *includeResolver) include(target, from string, stack []string) (*yaml.Node, error) {
+	p, err := includePath(target, from)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stack {
+		if s == p {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), p)
+		}
+	}
+	if len(stack) > r.maxDepth {
+		return nil, fmt.Errorf("include depth exceeds %d at %s", r.maxDepth, p)
+	}
+
+	file, ok := r.files[p]
+	if !ok {
+		if file, err = r.load(p); err != nil {
+			return nil, fmt.Errorf("include %s: %w", p, err)
+		}
+		if isFileTemplate(file.Name, file.Content) {
+			if file.Content, err = r.render(file); err != nil {
+				return nil, fmt.Errorf("include %s: %w", p, err)
+			}
+		}
+		r.files[p] = file
+	}
+
+	// 每次重新解析，被包含的节点会被修改
+	docs, err := decodeYAMLDocuments(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", p, err)
+	}
+	if len(docs) != 1 || len(docs[0].Content) == 0 {
+		return nil, fmt.Errorf("include %s: must contain exactly one YAML document", p)
+	}
+	if err := r.resolve(docs[0], p, append(stack, p)); err != nil {
+		return nil, fmt.Errorf("include %s: %w", p, err)
+	}
+	return docs[0].Content[0], nil
+}
+
+// 以 / 开头的路径相对配置仓库根目录，其他相对当前文件所在目录；不能跳出仓库
+func includePath(target, from string) (string, error) {
+	if strings.TrimSpace(target) == "" {
+		return "", errors.New("include path is empty")
+	}
+	p := path.Join(path.Dir(from), target)
+	if strings.HasPrefix(target, "/") {
+		p = path.Clean(strings.TrimPrefix(target, "/"))
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("include path %q escapes the config repository", target)
+	}
+	if !isYAMLFile(p) {
+		return "", fmt.Errorf("include path %q is not a YAML file", target)
+	}
+	return p, nil
+}
+
+// 是否包含顶层 include 键或 !include 标签
+func hasInclude(node *yaml.Node) bool {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 && mappingNode(node.Content[0], includeKey) != nil {
+		return true
+	}
+	if node.Tag == includeTag {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasInclude(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeYAMLDocuments(content string) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		doc := new(yaml.Node)
+		err := decoder.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func encodeYAMLDocuments(docs []*yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
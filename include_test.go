@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestIncludePath(t *testing.T) {
+	tests := []struct {
+		target, from string
+		want         string
+		wantErr      string
+	}{
+		{"lint.yml", "app/main/build.yml", "app/main/lint.yml", ""},
+		{"../../shared/lint.yml", "app/main/build.yml", "shared/lint.yml", ""},
+		{"/shared/lint.yml", "app/main/build.yml", "shared/lint.yml", ""},
+		{"../../../secrets.yml", "app/main/build.yml", "", "escapes the config repository"},
+		{"/../secrets.yml", "app/main/build.yml", "", "escapes the config repository"},
+		{"/shared/notes.txt", "app/main/build.yml", "", "not a YAML file"},
+		{" ", "app/main/build.yml", "", "include path is empty"},
+	}
+	for _, tt := range tests {
+		got, err := includePath(tt.target, tt.from)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("includePath(%q, %q) error = %v, want %q", tt.target, tt.from, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("includePath(%q, %q) = %q, %v, want %q", tt.target, tt.from, got, err, tt.want)
+		}
+	}
+}
+
+func TestIncludeResolverExpand(t *testing.T) {
+	files := map[string]string{
+		"shared/common.yml": "when:\n  - event: push\nsteps:\n  - name: prepare\n    image: alpine\n",
+		"shared/lint.yml":   "- name: lint\n  image: golangci/golangci-lint\n- name: vet\n  image: golang\n",
+		"shared/db.yml":     "image: postgres:16\nenvironment:\n  POSTGRES_PASSWORD: test\n",
+		"shared/nested.yml": "steps:\n  - !include lint.yml\n",
+		"loop/a.yml":        "include: b.yml\n",
+		"loop/b.yml":        "include: a.yml\n",
+		"deep/1.yml":        "include: 2.yml\n",
+		"deep/2.yml":        "include: 3.yml\n",
+		"deep/3.yml":        "steps: []\n",
+	}
+	loads := make(map[string]int)
+	r := newIncludeResolver(func(p string) (GiteaFile, error) {
+		loads[p]++
+		content, ok := files[p]
+		if !ok {
+			return GiteaFile{}, fs.ErrNotExist
+		}
+		return GiteaFile{Name: p, Path: p, Content: content}, nil
+	}, func(f GiteaFile) (string, error) { return f.Content, nil })
+	r.maxDepth = 2
+
+	tests := []struct {
+		name, content string
+		want          []string
+		wantErr       string
+	}{
+		{
+			name:    "top-level include merges lists and maps",
+			content: "include: /shared/common.yml\nwhen:\n  - event: tag\nsteps:\n  - name: build\n    image: golang\n",
+			want:    []string{"event: push", "event: tag", "name: prepare", "name: build"},
+		},
+		{
+			name:    "tag in a list splices the included list",
+			content: "steps:\n  - !include /shared/lint.yml\n  - name: build\n    image: golang\n",
+			want:    []string{"name: lint", "name: vet", "name: build"},
+		},
+		{
+			name:    "tag as a mapping value",
+			content: "services:\n  db: !include /shared/db.yml\nsteps: []\n",
+			want:    []string{"image: postgres:16", "POSTGRES_PASSWORD: test"},
+		},
+		{
+			name:    "nested includes are relative to the included file",
+			content: "include: /shared/nested.yml\n",
+			want:    []string{"name: lint"},
+		},
+		{
+			name:    "cycle",
+			content: "include: /loop/a.yml\n",
+			wantErr: "include cycle: app/main/build.yml -> loop/a.yml -> loop/b.yml -> loop/a.yml",
+		},
+		{
+			name:    "depth limit",
+			content: "include: /deep/1.yml\n",
+			wantErr: "include depth exceeds 2",
+		},
+		{
+			name:    "missing file",
+			content: "include: /shared/missing.yml\n",
+			wantErr: "include shared/missing.yml",
+		},
+		{
+			name:    "include must be a mapping at the top level",
+			content: "include: /shared/lint.yml\n",
+			wantErr: "must be a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.expand(GiteaFile{Name: "build.yml", Path: "app/main/build.yml", Content: tt.content})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expand() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expand() error = %v", err)
+			}
+			if strings.Contains(got, "include") {
+				t.Errorf("include directives left in output:\n%s", got)
+			}
+			last := -1
+			for _, w := range tt.want {
+				i := strings.Index(got, w)
+				if i < 0 || i < last {
+					t.Errorf("expected %q in order in:\n%s", w, got)
+				}
+				last = i
+			}
+		})
+	}
+
+	// 同一解析器内被包含的文件只读取一次
+	if loads["shared/lint.yml"] != 1 {
+		t.Errorf("shared/lint.yml loaded %d times, want 1", loads["shared/lint.yml"])
+	}
+}
+
+func TestIncludeResolverLeavesPlainFilesUntouched(t *testing.T) {
+	r := newIncludeResolver(func(p string) (GiteaFile, error) {
+		t.Fatalf("unexpected load of %s", p)
+		return GiteaFile{}, nil
+	}, nil)
+	content := "# comment kept\nsteps:\n  - name: build\n    image: golang # inline\n"
+	got, err := r.expand(GiteaFile{Name: "build.yml", Path: "build.yml", Content: content})
+	if err != nil || got != content {
+		t.Errorf("expand() = %q, %v, want content unchanged", got, err)
+	}
+}
+
+func TestResolveConfigIncludes(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/shared/common.yml":  "when:\n  - event: push\n",
+		"team/woodpeckerfiles/main/shared/lint.yml":    "- name: lint\n  image: golangci/golangci-lint\n",
+		"team/woodpeckerfiles/main/app/main/build.yml": "include: /shared/common.yml\nsteps:\n  - !include ../../shared/lint.yml\n  - name: build\n    image: golang\n",
+		"team/woodpeckerfiles/main/app/main/bad.yml":   "steps:\n  - !include ../../../../escape.yml\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+	if len(res.Configs) != 1 || res.Configs[0].Name != "build" {
+		t.Fatalf("unexpected configs: %+v", res.Configs)
+	}
+	for _, w := range []string{"event: push", "name: lint", "name: build"} {
+		if !strings.Contains(res.Configs[0].Data, w) {
+			t.Errorf("expected %q in:\n%s", w, res.Configs[0].Data)
+		}
+	}
+	if len(res.Errors) != 1 || res.Errors[0].File != "app/main/bad.yml" || !strings.Contains(res.Errors[0].Error, "escapes the config repository") {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestValidateTreeIncludes(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"shared/lint.yml":    "- name: lint\n  image: golangci/golangci-lint\n",
+		"app/main/build.yml": "steps:\n  - !include /shared/lint.yml\n",
+		"app/main/loop.yml":  "include: loop.yml\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 被包含的片段不单独校验，build.yml 展开后合法
+	if len(diags) != 1 || diags[0].File != "app/main/loop.yml" || !strings.Contains(diags[0].Message, "include cycle") {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+)
+
+// Jsonnet pipeline 文件；.libsonnet 只作为库被 import，不单独生成 pipeline
+func isJsonnetFile(name string) bool {
+	return strings.HasSuffix(name, ".jsonnet") || isJsonnetLibrary(name)
+}
+
+func isJsonnetLibrary(name string) bool {
+	return strings.HasSuffix(name, ".libsonnet")
+}
+
+// 从配置仓库读取 import 的文件，路径规则与 include 相同
+type jsonnetImporter struct {
+	load  func(p string) (GiteaFile, error)
+	cache map[string]jsonnet.Contents
+}
+
+func (i *jsonnetImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	p, err := jsonnetImportPath(importedPath, importedFrom)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	if contents, ok := i.cache[p]; ok {
+		return contents, p, nil
+	}
+	// 文件通过列出配置目录读取，目录列表只包含配置文件，.json、.txt 等无法读取
+	if !isConfigFile(path.Base(p)) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %s: only .libsonnet, .jsonnet and other config files (.yml, .yaml, .star, .cue) can be imported", p)
+	}
+	file, err := i.load(p)
+	if err != nil {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %s: %w", p, err)
+	}
+	contents := jsonnet.MakeContents(file.Content)
+	i.cache[p] = contents
+	return contents, p, nil
+}
+
+// 以 / 开头的路径相对配置仓库根目录，其他相对当前文件所在目录；不能跳出仓库
+func jsonnetImportPath(target, from string) (string, error) {
+	p := path.Join(path.Dir(from), target)
+	if strings.HasPrefix(target, "/") {
+		p = path.Clean(strings.TrimPrefix(target, "/"))
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("import path %q escapes the config repository", target)
+	}
+	return p, nil
+}
+
+// 执行一个 .jsonnet 文件：对象生成一个 pipeline，数组中的每个对象各生成一个（名称加 -1、-2 后缀）。
+// 模板数据通过 std.extVar('repo')、std.extVar('pipeline') 等传入
+func evaluateJsonnet(file GiteaFile, data TemplateData, location fileTemplateLocation, load func(p string) (GiteaFile, error)) ([]ConfigFile, error) {
+	input, err := generatorInput(data, location)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := jsonnet.MakeVM()
+	// 入口文件已经读取，放进缓存后按文件执行，相对 import 以它所在目录为准
+	vm.Importer(&jsonnetImporter{load: load, cache: map[string]jsonnet.Contents{file.Path: jsonnet.MakeContents(file.Content)}})
+	for key, value := range input {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		vm.ExtCode(key, string(b))
+	}
+
+	output, err := vm.EvaluateFile(file.Path)
+	if err != nil {
+		return nil, fmt.Errorf("jsonnet: %s", singleLineError(err))
+	}
+
+	var result interface{}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return nil, err
+	}
+	configs, err := generatedConfigs(file, result)
+	if err != nil {
+		return nil, fmt.Errorf("jsonnet: %w", err)
+	}
+	return configs, nil
+}
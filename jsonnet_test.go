@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateJsonnet(t *testing.T) {
+	libs := map[string]string{
+		"lib/steps.libsonnet": "{ goStep(name, cmd):: { name: name, image: 'golang:1.24', commands: [cmd] } }\n",
+	}
+	load := func(p string) (GiteaFile, error) {
+		content, ok := libs[p]
+		if !ok {
+			return GiteaFile{}, fs.ErrNotExist
+		}
+		return GiteaFile{Name: p, Path: p, Content: content}, nil
+	}
+	data := TemplateData{
+		Repo:     RepoInfo{Owner: "team", Name: "app"},
+		Pipeline: PipelineInfo{Branch: "main", Event: "push"},
+	}
+	location := fileTemplateLocation{Path: "app/main"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "object with imports and ext vars",
+			content: "local s = import '/lib/steps.libsonnet';\n{ steps: [s.goStep('test', 'go test ./...')], when: { branch: std.extVar('pipeline').branch } }\n",
+			want:    map[string]string{"build": "steps:\n    - commands:\n        - go test ./...\n      image: golang:1.24\n      name: test\nwhen:\n    branch: main\n"},
+		},
+		{
+			name:    "array produces one config per element",
+			content: "[{ steps: [{ name: 'a', image: std.extVar('repo').name }] }, { steps: [{ name: 'b', image: std.extVar('config').Path }] }]\n",
+			want: map[string]string{
+				"build-1": "steps:\n    - image: app\n      name: a\n",
+				"build-2": "steps:\n    - image: app/main\n      name: b\n",
+			},
+		},
+		{
+			name:    "runtime errors include file and line",
+			content: "{\n  steps: [],\n  when: error 'boom',\n}\n",
+			wantErr: "app/main/build.jsonnet:3:",
+		},
+		{
+			name:    "imports cannot escape the config repository",
+			content: "import '../../../secret.libsonnet'\n",
+			wantErr: "escapes the config repository",
+		},
+		{
+			name:    "only config files can be imported",
+			content: "{ steps: [], when: { branch: importstr '/lib/branches.txt' } }\n",
+			wantErr: "only .libsonnet, .jsonnet and other config files",
+		},
+		{
+			name:    "output must be an object",
+			content: "'steps'\n",
+			wantErr: "must produce an object or a list of objects",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := GiteaFile{Name: "build.jsonnet", Path: "app/main/build.jsonnet", Content: tt.content}
+			configs, err := evaluateJsonnet(file, data, location, load)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evaluateJsonnet() error = %v, want %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "\n") {
+					t.Errorf("error should be a single line: %q", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluateJsonnet() error = %v", err)
+			}
+			if len(configs) != len(tt.want) {
+				t.Fatalf("got %d configs, want %d: %+v", len(configs), len(tt.want), configs)
+			}
+			for _, c := range configs {
+				if c.Data != tt.want[c.Name] {
+					t.Errorf("config %s =\n%s\nwant:\n%s", c.Name, c.Data, tt.want[c.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveConfigJsonnet(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/lib/common.libsonnet":    "{ image: 'golang:1.24' }\n",
+		"team/woodpeckerfiles/main/app/main/build.jsonnet":  "local c = import '../../lib/common.libsonnet';\n{ steps: [{ name: 'build', image: c.image }] }\n",
+		"team/woodpeckerfiles/main/app/main/util.libsonnet": "{}\n",
+		"team/woodpeckerfiles/main/app/main/broken.jsonnet": "{ steps: [ }\n",
+		"team/woodpeckerfiles/main/app/main/test.yml":       "steps:\n  - name: test\n    image: golang\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+	configs := make(map[string]string)
+	for _, c := range res.Configs {
+		configs[c.Name] = c.Data
+	}
+	if len(configs) != 2 || !strings.Contains(configs["build"], "image: golang:1.24") || configs["test"] == "" {
+		t.Errorf("unexpected configs: %+v", res.Configs)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].File != "app/main/broken.jsonnet" || !strings.Contains(res.Errors[0].Error, "app/main/broken.jsonnet:1:") {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestValidateTreeJsonnet(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.jsonnet": "{ steps: [] }\n",
+		"app/main/lib.libsonnet": "{\n  a: ,\n}\n",
+		"app/main/uses.jsonnet":  "(import 'lib.libsonnet') + { steps: [] }\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].File != "app/main/lib.libsonnet" || diags[0].Line != 2 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
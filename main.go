@@ -1,39 +1,126 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
 	"code.gitea.io/sdk/gitea"
-	"gopkg.in/yaml.v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // 配置变量
 var (
 	// 基础配置
 	Debug      = getEnvBool("PLUGIN_DEBUG", false)
+	LogLevel   = getEnv("LOG_LEVEL", "")
+	LogFormat  = getEnv("LOG_FORMAT", "text")
 	ServerType = getEnv("SERVERTYPE", "gitea")
 	Token      = getEnv("TOKEN", "")
+	TokenFile  = getEnv("TOKEN_FILE", "")
 	ServerURL  = getEnv("SERVER_URL", "https://git.local.lan")
 
 	// 模板配置 - Woodpecker 风格（优先）+ Drone 兼容
-	NamespaceTemplate = getEnvWithFallback("WOODPECKER_CONFIG_NAMESPACE_TEMP", "DRONE_CONFIG_NAMESPACE_TEMP", "{{ .Repo.Owner }}")
+	NamespaceTemplate = lookupEnvWithFallback("WOODPECKER_CONFIG_NAMESPACE_TEMP", "DRONE_CONFIG_NAMESPACE_TEMP", "{{ .Repo.Owner }}")
 	RepoNameTemplate  = getEnvWithFallback("WOODPECKER_CONFIG_REPONAME_TEMP", "DRONE_CONFIG_REPONAME_TEMP", "woodpeckerfiles")
 	BranchTemplate    = getEnvWithFallback("WOODPECKER_CONFIG_BRANCH_TEMP", "DRONE_CONFIG_BRANCH_TEMP", "{{ .Pipeline.Branch }}")
 	PathTemplate      = getEnvWithFallback("WOODPECKER_CONFIG_YAMLPATH_TEMP", "DRONE_CONFIG_YAMLPATH_TEMP", "{{ .Repo.Name }}/{{ .Pipeline.Branch }}")
 
+	// 模板中 env 函数可以读取的环境变量（逗号分隔，支持 * 通配）
+	TemplateEnvAllowlist = getEnv("TEMPLATE_ENV_ALLOWLIST", "")
+
+	// 渲染 pipeline 文件模板时的额外变量（每行一个 KEY=value）
+	TemplateVars = getEnv("TEMPLATE_VARS", "")
+
+	// include 的最大嵌套深度
+	IncludeMaxDepth = getEnvInt("INCLUDE_MAX_DEPTH", 10)
+
+	// 分层覆盖：逗号分隔的路径模板，同名文件按顺序深度合并（为空时只使用 path 模板）
+	OverlayLayers = getEnv("OVERLAY_LAYERS", "")
+
+	// Starlark 单个文件的执行步数和时间上限
+	StarlarkMaxSteps = getEnvInt("STARLARK_MAX_STEPS", 50000)
+	StarlarkTimeout  = getEnvDuration("STARLARK_TIMEOUT", 5*time.Second)
+
+	// 矩阵文件最多展开的组合数（0 表示不限制）
+	MatrixMaxCombinations = getEnvInt("MATRIX_MAX_COMBINATIONS", 100)
+
 	// 兼容旧版配置
-	GiteaURL   = getEnv("GITEA_URL", ServerURL)
-	GiteaToken = getEnv("GITEA_TOKEN", Token)
+	GiteaURL       = getEnv("GITEA_URL", ServerURL)
+	GiteaToken     = getEnv("GITEA_TOKEN", "")
+	GiteaTokenFile = getEnv("GITEA_TOKEN_FILE", "")
+
+	// Bitbucket app password 对应的用户名，为空时 TOKEN 作为 Bearer token
+	BitbucketUsername = getEnv("BITBUCKET_USERNAME", "")
+
+	// GitHub App 认证（设置后替代 TOKEN）
+	GitHubAppID             = getEnv("GITHUB_APP_ID", "")
+	GitHubAppPrivateKey     = getEnv("GITHUB_APP_PRIVATE_KEY", "")
+	GitHubAppPrivateKeyFile = getEnv("GITHUB_APP_PRIVATE_KEY_FILE", "")
+	GitHubAppInstallationID = getEnv("GITHUB_APP_INSTALLATION_ID", "")
+
+	// GitLab 递归列出子目录中的配置文件
+	GitLabRecursive = getEnvBool("GITLAB_RECURSIVE", false)
+
+	// 归档模式：一次下载整个配置仓库（gitea/forgejo/github/gitlab）
+	FetchMode         = getEnv("FETCH_MODE", "api")
+	ArchiveFormat     = getEnv("ARCHIVE_FORMAT", "tar.gz")
+	ArchiveCacheSize  = getEnvInt("ARCHIVE_CACHE_SIZE", 32)
+	ArchiveCacheMaxMB = getEnvInt("ARCHIVE_CACHE_MAX_MB", 256)
+
+	// 服务器版本（forgejo/gogs），为空时自动探测
+	ServerVersion = getEnv("SERVER_VERSION", "")
+
+	// 本地目录配置源（SERVERTYPE=file）
+	ConfigDir        = getEnv("CONFIG_DIR", "/config")
+	FilePathTemplate = getEnv("FILE_PATH_TEMPLATE", "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	// 本地 git 镜像配置源（SERVERTYPE=git）
+	GitURLTemplate              = getEnv("GIT_URL_TEMPLATE", "{{ .ServerURL }}/{{ .Namespace }}/{{ .Repo }}.git")
+	GitCacheDir                 = getEnv("GIT_CACHE_DIR", filepath.Join(os.TempDir(), "woodpecker-config-provider", "git"))
+	GitFetchInterval            = getEnvDuration("GIT_FETCH_INTERVAL", time.Minute)
+	GitUsername                 = getEnv("GIT_USERNAME", "oauth2")
+	GitSSHKeyFile               = getEnv("GIT_SSH_KEY_FILE", "")
+	GitSSHKeyPassphrase         = getEnv("GIT_SSH_KEY_PASSPHRASE", "")
+	GitSSHKnownHosts            = getEnv("GIT_SSH_KNOWN_HOSTS", "")
+	GitSSHInsecureIgnoreHostKey = getEnvBool("GIT_SSH_INSECURE_IGNORE_HOST_KEY", false)
+	GitWebhookSecret            = getEnv("GIT_WEBHOOK_SECRET", "")
+	GitWebhookSecretFile        = getEnv("GIT_WEBHOOK_SECRET_FILE", "")
+
+	// HTTP 配置源（SERVERTYPE=http）
+	HTTPURLTemplate = getEnv("HTTP_URL_TEMPLATE", "{{ .ServerURL }}/{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}/")
+	HTTPHeaders     = getEnv("HTTP_HEADERS", "")
+
+	// 健康检查与管理接口
+	ReadinessRepo     = getEnv("READINESS_REPO", "")
+	ReadinessCacheTTL = getEnvDuration("READINESS_CACHE_TTL", 30*time.Second)
+	AdminToken        = getEnv("ADMIN_TOKEN", "")
+	AdminTokenFile    = getEnv("ADMIN_TOKEN_FILE", "")
+
+	// 按 namespace/仓库选择凭据的映射文件
+	CredentialsFile = getEnv("CREDENTIALS_FILE", "")
+
+	// 追踪配置（未设置 endpoint 时关闭导出）
+	OTLPEndpoint       = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	OTLPTracesEndpoint = getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
 )
 
+const serviceVersion = "2.0.0"
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -54,6 +141,16 @@ func getEnvWithFallback(primary, fallback, defaultValue string) string {
 	return defaultValue
 }
 
+// 与 getEnvWithFallback 相同，但显式设置为空值也生效（GitLab 用完整路径或数字 ID 时 namespace 为空）
+func lookupEnvWithFallback(primary, fallback, defaultValue string) string {
+	for _, key := range []string{primary, fallback} {
+		if value, ok := os.LookupEnv(key); ok {
+			return value
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	value := os.Getenv(key)
 	if value == "" {
@@ -62,42 +159,28 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return value == "true" || value == "1" || value == "yes"
 }
 
-func debugLog(format string, args ...interface{}) {
-	if Debug {
-		fmt.Printf("[DEBUG] "+format+"\n", args...)
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return d
 }
 
-// Woodpecker 请求结构
-type ConfigRequest struct {
-	Repo     RepoInfo     `json:"repo"`
-	Pipeline PipelineInfo `json:"pipeline"`
-	Config   ConfigInfo   `json:"config"`
-}
-
-type RepoInfo struct {
-	Name      string `json:"name"`
-	Owner     string `json:"owner"`
-	Namespace string `json:"-"` // 别名，用于模板，从 Owner 复制值
-	FullName  string `json:"full_name"`
-	CloneURL  string `json:"clone_url"`
-	Branch    string `json:"default_branch"`
-}
-
-type PipelineInfo struct {
-	Branch string `json:"branch"`
-	Commit string `json:"commit"`
-	Ref    string `json:"ref"`
-}
-
-type ConfigInfo struct {
-	Data string `json:"data"`
-}
-
-// 模板数据
-type TemplateData struct {
-	Repo     RepoInfo
-	Pipeline PipelineInfo
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
 }
 
 // Woodpecker 响应结构（多文件）
@@ -118,107 +201,178 @@ type GiteaFile struct {
 	Content string `json:"content"`
 }
 
-// 渲染模板
-func renderTemplate(tmplStr string, data TemplateData) (string, error) {
-	tmpl, err := template.New("config").Parse(tmplStr)
-	if err != nil {
-		return "", fmt.Errorf("parse template error: %w", err)
+// 是否为 pipeline YAML 文件（.yml/.yaml，或模板 .yml.tmpl/.yaml.tmpl）
+func isYAMLFile(name string) bool {
+	name = strings.TrimSuffix(name, ".tmpl")
+	return strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml")
+}
+
+// 配置目录中需要读取的文件：YAML 以及 Jsonnet、Starlark、CUE
+func isConfigFile(name string) bool {
+	return isYAMLFile(name) || isJsonnetFile(name) || isStarlarkFile(name) || isCUEFile(name)
+}
+
+// 配置目录不存在，各后端的 404 都包装为这个错误，分层覆盖时只跳过这种层
+var errConfigNotFound = errors.New("config path not found")
+
+// 保留后端原始错误信息，同时可以用 errors.Is(err, errConfigNotFound) 判断
+func notFoundError(err error) error {
+	return fmt.Errorf("%w: %w", errConfigNotFound, err)
+}
+
+// 根据服务器类型从 Git 服务器获取目录下的配置文件
+func fetchFilesFromGitServer(ctx context.Context, namespace, repo, branch, path string) ([]GiteaFile, error) {
+	ctx = withCredentials(ctx, namespace, repo)
+	if strings.EqualFold(FetchMode, "archive") && supportsArchive() {
+		return fetchFilesFromArchive(ctx, namespace, repo, branch, path)
 	}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("execute template error: %w", err)
+	switch strings.ToLower(ServerType) {
+	case "gitea":
+		return fetchFilesFromGitea(ctx, namespace, repo, branch, path)
+	case "github":
+		return fetchFilesFromGitHub(ctx, namespace, repo, branch, path)
+	case "gitlab":
+		return fetchFilesFromGitLab(ctx, namespace, repo, branch, path)
+	case "file":
+		return fetchFilesFromDir(ctx, namespace, repo, branch, path)
+	case "git":
+		return fetchFilesFromGit(ctx, namespace, repo, branch, path)
+	case "forgejo":
+		return fetchFilesFromForgejo(ctx, namespace, repo, branch, path)
+	case "gogs":
+		return fetchFilesFromGogs(ctx, namespace, repo, branch, path)
+	case "bitbucket":
+		return fetchFilesFromBitbucket(ctx, namespace, repo, branch, path)
+	case "bitbucket-server":
+		return fetchFilesFromBitbucketServer(ctx, namespace, repo, branch, path)
+	case "http":
+		return fetchFilesFromHTTP(ctx, namespace, repo, branch, path)
+	default:
+		return nil, fmt.Errorf("unsupported server type: %s", ServerType)
 	}
+}
 
-	result := buf.String()
-	debugLog("Template: %s => %s", tmplStr, result)
-	return result, nil
+// 创建 HTTP 客户端（支持自签名证书）
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
 }
 
-// 从 Git 服务器获取文件
-func fetchFilesFromGitServer(req ConfigRequest) ([]GiteaFile, error) {
-	// 准备模板数据
-	data := TemplateData{
-		Repo:     req.Repo,
-		Pipeline: req.Pipeline,
+// 逐段转义 URL 路径，保留分隔符 /
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
 	}
+	return strings.Join(parts, "/")
+}
+
+// 发送 GET 请求并返回响应体，非 200 响应视为错误
+func getRaw(ctx context.Context, rawURL string, header http.Header) ([]byte, error) {
+	_, body, err := httpGet(ctx, rawURL, header)
+	return body, err
+}
 
-	// 渲染模板
-	namespace, err := renderTemplate(NamespaceTemplate, data)
+// 发送 GET 请求，返回 Content-Type 和响应体
+func httpGet(ctx context.Context, rawURL string, header http.Header) (string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("render namespace template: %w", err)
+		return "", nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
-	repoName, err := renderTemplate(RepoNameTemplate, data)
+	resp, err := newHTTPClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("render reponame template: %w", err)
+		return "", nil, err
 	}
+	defer resp.Body.Close()
 
-	branch, err := renderTemplate(BranchTemplate, data)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("render branch template: %w", err)
+		return "", nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil, notFoundError(fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
 	}
+	return resp.Header.Get("Content-Type"), body, nil
+}
 
-	path, err := renderTemplate(PathTemplate, data)
+// 发送 GET 请求并把 JSON 响应解码到 v
+func getJSON(ctx context.Context, rawURL string, header http.Header, v any) error {
+	body, err := getRaw(ctx, rawURL, header)
 	if err != nil {
-		return nil, fmt.Errorf("render path template: %w", err)
+		return err
 	}
-
-	debugLog("Resolved values - Namespace: %s, Repo: %s, Branch: %s, Path: %s",
-		namespace, repoName, branch, path)
-
-	// 根据服务器类型调用相应的函数
-	switch strings.ToLower(ServerType) {
-	case "gitea":
-		return fetchFilesFromGitea(namespace, repoName, branch, path)
-	case "github":
-		return fetchFilesFromGitHub(namespace, repoName, branch, path)
-	case "gitlab":
-		return fetchFilesFromGitLab(namespace, repoName, branch, path)
-	default:
-		return nil, fmt.Errorf("unsupported server type: %s", ServerType)
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decode %s: %w", rawURL, err)
 	}
+	return nil
+}
+
+// 创建 Gitea 客户端
+func newGiteaClient(ctx context.Context) (*gitea.Client, error) {
+	return gitea.NewClient(GiteaURL,
+		gitea.SetContext(ctx),
+		gitea.SetToken(currentGiteaToken(ctx)),
+		gitea.SetHTTPClient(newHTTPClient()),
+	)
 }
 
 // 从 Gitea 获取目录下所有文件
-func fetchFilesFromGitea(namespace, repo, branch, path string) ([]GiteaFile, error) {
-	debugLog("fetchFilesFromGitea - namespace: %s, repo: %s, branch: %s, path: %s",
-		namespace, repo, branch, path)
+func fetchFilesFromGitea(ctx context.Context, namespace, repo, branch, path string) (_ []GiteaFile, err error) {
+	ctx, span := startSpan(ctx, "gitea.fetch_files", resolvedAttributes(namespace, repo, branch, path)...)
+	defer func() { endSpan(span, err) }()
+
+	slog.DebugContext(ctx, "fetch files from gitea",
+		"namespace", namespace, "repo", repo, "branch", branch, "path", path)
 
 	// 创建 Gitea 客户端
-	client, err := gitea.NewClient(GiteaURL,
-		gitea.SetToken(GiteaToken),
-		gitea.SetHTTPClient(&http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		}),
-	)
+	client, err := newGiteaClient(ctx)
 	if err != nil {
-		debugLog("ERROR: Failed to create Gitea client: %v", err)
+		slog.ErrorContext(ctx, "failed to create gitea client", "error", err)
 		return nil, err
 	}
+	return listGiteaFiles(ctx, client, "gitea", namespace, repo, branch, path)
+}
 
+// 通过 Gitea API（Gitea 与 Forgejo 共用）读取目录下的配置文件
+func listGiteaFiles(ctx context.Context, client *gitea.Client, spanPrefix, namespace, repo, branch, path string) ([]GiteaFile, error) {
 	// 获取目录内容列表
-	contentsList, _, err := client.ListContents(namespace, repo, branch, path)
+	_, listSpan := startSpan(ctx, spanPrefix+".list_contents")
+	contentsList, resp, err := client.ListContents(namespace, repo, branch, path)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		err = notFoundError(err)
+	}
+	endSpan(listSpan, err)
 	if err != nil {
-		debugLog("ERROR: Failed to get directory contents: %v", err)
+		slog.ErrorContext(ctx, "failed to get directory contents", "error", err)
 		return nil, err
 	}
 
-	debugLog("Found %d items in directory", len(contentsList))
+	slog.DebugContext(ctx, "found items in directory", "count", len(contentsList))
 
 	// 处理每个文件
 	var result []GiteaFile
 	for _, content := range contentsList {
-		// 只处理 .yml 和 .yaml 文件
-		if content.Type == "file" && (strings.HasSuffix(content.Name, ".yml") || strings.HasSuffix(content.Name, ".yaml")) {
-			debugLog("  Processing file: %s", content.Name)
+		// 只处理配置文件（.yml/.yaml、.jsonnet/.libsonnet、.star、.cue）
+		if content.Type == "file" && isConfigFile(content.Name) {
+			slog.DebugContext(ctx, "processing file", "file", content.Name)
 
 			// 获取文件内容
+			_, fileSpan := startSpan(ctx, spanPrefix+".get_file", attribute.String("config.file", content.Path))
 			fileContent, _, err := client.GetFile(namespace, repo, branch, content.Path)
+			endSpan(fileSpan, err)
 			if err != nil {
-				debugLog("    ERROR: Failed to fetch file: %v", err)
+				slog.ErrorContext(ctx, "failed to fetch file", "file", content.Path, "error", err)
 				continue
 			}
 
@@ -230,84 +384,68 @@ func fetchFilesFromGitea(namespace, repo, branch, path string) ([]GiteaFile, err
 				Content: string(fileContent),
 			}
 
-			debugLog("    ✓ Loaded %s (%d bytes)", content.Name, len(giteaFile.Content))
+			slog.DebugContext(ctx, "loaded file", "file", content.Name, "bytes", len(giteaFile.Content))
 			result = append(result, giteaFile)
 		} else {
-			debugLog("  Skipping: %s (type: %s)", content.Name, content.Type)
+			slog.DebugContext(ctx, "skipping entry", "name", content.Name, "type", content.Type)
 		}
 	}
 
-	debugLog("Total files loaded: %d", len(result))
+	slog.DebugContext(ctx, "total files loaded", "count", len(result))
 	return result, nil
 }
 
 // 处理配置请求
 func handleConfigRequest(w http.ResponseWriter, r *http.Request) {
-	if Debug {
-		fmt.Println("=== Config Request Start ===")
-	}
+	// 每个请求分配一个请求 ID，并通过 context 传递给各个 fetcher
+	requestID := requestIDFromHeader(r.Header.Get("X-Request-ID"))
+	ctx := extractTraceContext(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = withRequestID(ctx, requestID)
+	w.Header().Set("X-Request-ID", requestID)
+
+	ctx, span := startSpan(ctx, "POST /ciconfig", attribute.String("request.id", requestID))
+	defer span.End()
+
+	slog.DebugContext(ctx, "config request start")
 
 	// 1. 解析请求
 	var req ConfigRequest
 	body, _ := io.ReadAll(r.Body)
-	debugLog("Request body: %s", string(body))
+	slog.DebugContext(ctx, "request body", "body", string(body))
 
 	if err := json.Unmarshal(body, &req); err != nil {
-		debugLog("ERROR: Failed to parse request: %v", err)
+		slog.WarnContext(ctx, "failed to parse request", "error", err)
+		span.SetStatus(codes.Error, "invalid request body")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 复制 Owner 到 Namespace（用于模板兼容性）
-	req.Repo.Namespace = req.Repo.Owner
-
-	debugLog("Parsed request - Repo: %s, Branch: %s, Owner: %s",
-		req.Repo.Name, req.Pipeline.Branch, req.Repo.Owner)
+	slog.InfoContext(ctx, "config request",
+		"repo", req.Repo.FullName, "branch", req.Pipeline.Branch, "owner", req.Repo.Owner)
+	span.SetAttributes(
+		attribute.String("repo.full_name", req.Repo.FullName),
+		attribute.String("pipeline.branch", req.Pipeline.Branch),
+	)
 
-	// 2. 从 Git 服务器获取所有配置文件
-	files, err := fetchFilesFromGitServer(req)
+	// 2. 解析模板并从 Git 服务器获取所有配置文件
+	res, err := resolveConfig(ctx, req)
 	if err != nil {
-		debugLog("ERROR: Failed to fetch files: %v", err)
+		slog.WarnContext(ctx, "failed to fetch files, falling back to repository config", "error", err)
+		span.RecordError(err)
 		// 如果目录不存在，返回 204（使用仓库自己的配置）
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	configs := res.Configs
 
-	debugLog("Found %d config files", len(files))
-
-	// 3. 构建响应
-	var configs []ConfigFile
-
-	for _, file := range files {
-		// 去掉 .yml 后缀作为 pipeline 名称
-		name := strings.TrimSuffix(file.Name, ".yml")
-		name = strings.TrimSuffix(name, ".yaml")
-
-		debugLog("  - %s (%d bytes)", file.Name, len(file.Content))
-
-		// SDK 已经返回原始 YAML 内容，直接使用
-		// 验证是否是有效的 YAML
-		var testData interface{}
-		if err := yaml.Unmarshal([]byte(file.Content), &testData); err != nil {
-			debugLog("    WARNING: YAML validation failed: %v", err)
-		} else {
-			debugLog("    ✓ YAML validation passed")
-		}
-
-		configs = append(configs, ConfigFile{
-			Name: name,
-			Data: file.Content,
-		})
-	}
-
-	// 4. 返回多个配置文件
+	// 3. 返回多个配置文件
 	response := ConfigResponse{
 		Configs: configs,
 	}
 
-	if Debug {
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
 		responseJSON, _ := json.MarshalIndent(response, "", "  ")
-		fmt.Printf("Response (formatted):\n%s\n", string(responseJSON))
+		slog.DebugContext(ctx, "response", "body", string(responseJSON))
 	}
 
 	// 设置 HTTP headers
@@ -317,39 +455,16 @@ func handleConfigRequest(w http.ResponseWriter, r *http.Request) {
 	// 返回 JSON
 	json.NewEncoder(w).Encode(response)
 
-	if Debug {
-		fmt.Println("=== Config Request End ===")
-	}
+	span.SetAttributes(attribute.Int("configs.count", len(configs)))
+	slog.InfoContext(ctx, "config request done", "configs", len(configs))
 }
 
-func main() {
-	fmt.Println("Woodpecker Config Provider (Enhanced Multi-file) starting on :8000")
-	fmt.Println("Server Type:", ServerType)
-	fmt.Println("Server URL:", ServerURL)
-	fmt.Println("Template Repo:", RepoNameTemplate)
-	fmt.Println("Debug Mode:", Debug)
-
-	if Token == "" && GiteaToken == "" {
-		fmt.Println("WARNING: TOKEN is not set!")
-	} else {
-		tokenToShow := Token
-		if tokenToShow == "" {
-			tokenToShow = GiteaToken
-		}
-		if len(tokenToShow) > 16 {
-			fmt.Printf("Token configured: %s...%s\n", tokenToShow[:8], tokenToShow[len(tokenToShow)-8:])
-		}
-	}
-
-	fmt.Println("\nTemplate Configuration:")
-	fmt.Println("  Namespace:", NamespaceTemplate)
-	fmt.Println("  RepoName:", RepoNameTemplate)
-	fmt.Println("  Branch:", BranchTemplate)
-	fmt.Println("  Path:", PathTemplate)
+// 配置路由
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
 
-	// 配置路由
-	http.HandleFunc("/ciconfig", func(w http.ResponseWriter, r *http.Request) {
-		debugLog("Received request: %s %s", r.Method, r.URL.Path)
+	mux.HandleFunc("/ciconfig", func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("received request", "method", r.Method, "path", r.URL.Path)
 
 		if r.Method == "POST" {
 			handleConfigRequest(w, r)
@@ -359,25 +474,18 @@ func main() {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	})
 
-	// Health check endpoint
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		debugLog("Health check: %s %s", r.Method, r.URL.Path)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /admin/config", handleAdminConfig)
+	mux.HandleFunc("POST /hooks/git", handleGitWebhook)
 
+	// 服务信息（配置详情见 /admin/config）
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.URL.Path == "/" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]interface{}{
+			writeJSON(w, http.StatusOK, map[string]interface{}{
 				"status":  "ok",
 				"service": "Woodpecker Config Provider (Enhanced Multi-file)",
-				"version": "2.0.0",
-				"config": map[string]string{
-					"server_type":    ServerType,
-					"namespace_tmpl": NamespaceTemplate,
-					"reponame_tmpl":  RepoNameTemplate,
-					"branch_tmpl":    BranchTemplate,
-					"path_tmpl":      PathTemplate,
-					"debug":          fmt.Sprintf("%v", Debug),
-				},
+				"version": serviceVersion,
 			})
 			return
 		}
@@ -385,6 +493,72 @@ func main() {
 		http.NotFound(w, r)
 	})
 
-	fmt.Println("\nStarting HTTP server on :8000")
-	http.ListenAndServe(":8000", nil)
+	return mux
+}
+
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// serve 子命令：启动 HTTP 服务
+func runServe(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", ":8000", "HTTP 监听地址")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	setupLogger(os.Stdout)
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	slog.Info("Woodpecker Config Provider (Enhanced Multi-file) starting",
+		"addr", *addr,
+		"server_type", ServerType,
+		"server_url", ServerURL,
+		"template_repo", RepoNameTemplate,
+		"debug", Debug,
+		"log_level", parseLogLevel(LogLevel, Debug).String())
+
+	// 不输出任何 token 字符，只提示是否已配置
+	if githubAppEnabled() {
+		slog.Info("github app configured", "app_id", GitHubAppID)
+	} else if currentToken(context.Background()) == "" && currentGiteaToken(context.Background()) == "" {
+		slog.Warn("TOKEN is not set")
+	} else {
+		slog.Info("token configured")
+	}
+
+	if err := setupCredentials(); err != nil {
+		slog.Error("failed to load credentials", "error", err)
+		return 1
+	}
+	if err := setupTemplates(); err != nil {
+		slog.Error("failed to compile templates", "error", err)
+		return 1
+	}
+
+	slog.Info("template configuration",
+		"namespace", NamespaceTemplate,
+		"reponame", RepoNameTemplate,
+		"branch", BranchTemplate,
+		"path", PathTemplate,
+		"overlay_layers", OverlayLayers)
+
+	if strings.ToLower(ServerType) == "git" {
+		startGitFetcher(context.Background(), GitFetchInterval)
+	}
+
+	slog.Info("starting HTTP server", "addr", *addr)
+	if err := http.ListenAndServe(*addr, newServeMux()); err != nil {
+		slog.Error("http server stopped", "error", err)
+		return 1
+	}
+	return 0
 }
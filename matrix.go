@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 矩阵文件后缀（可以再加 .tmpl 作为文件模板）
+var matrixSuffixes = []string{".matrix.yml", ".matrix.yaml"}
+
+// matrix 下的保留 key，其他 key 都是维度
+const (
+	matrixInclude = "include"
+	matrixExclude = "exclude"
+	matrixName    = "name"
+)
+
+// 名称中只保留字母、数字、点、下划线和连字符
+var matrixNameInvalid = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// 矩阵的一个组合
+type matrixCombination struct {
+	keys   []string
+	values map[string]string
+}
+
+// 是否是需要展开的矩阵文件，如 test.matrix.yml
+func isMatrixFile(name string) bool {
+	name = strings.TrimSuffix(name, ".tmpl")
+	for _, suffix := range matrixSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 把矩阵文件展开为多个 pipeline：每个组合替换 body 中的 ${维度}，名称为 <文件名>-<各维度的值>
+func expandMatrix(file GiteaFile, content string) ([]ConfigFile, error) {
+	docs, err := decodeYAMLDocuments(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 || len(docs[0].Content) == 0 || docs[0].Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("matrix file must contain exactly one YAML mapping")
+	}
+	root := docs[0].Content[0]
+	matrix := mappingNode(root, "matrix")
+	if matrix == nil {
+		return nil, fmt.Errorf("matrix file has no top-level \"matrix\" key")
+	}
+	deleteMappingKey(root, "matrix")
+
+	combinations, nameTemplate, err := matrixCombinations(matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	base := pipelineName(file.Name)
+	seen := make(map[string]bool)
+	var configs []ConfigFile
+	for _, c := range combinations {
+		suffix := substituteMatrix(nameTemplate, c)
+		if nameTemplate == "" {
+			var values []string
+			for _, key := range c.keys {
+				values = append(values, c.values[key])
+			}
+			suffix = strings.Join(values, "-")
+		}
+		name := base + "-" + strings.Trim(matrixNameInvalid.ReplaceAllString(suffix, "-"), "-")
+		if seen[name] {
+			return nil, fmt.Errorf("matrix combinations produce duplicate pipeline name %q", name)
+		}
+		seen[name] = true
+
+		body := copyNode(root)
+		substituteMatrixNode(body, c)
+		data, err := encodeYAMLDocuments([]*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{body}}})
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, ConfigFile{Name: name, Data: data})
+	}
+	return configs, nil
+}
+
+// 计算所有组合：各维度的笛卡尔积，去掉 exclude 匹配的组合，再追加 include 的组合
+func matrixCombinations(matrix *yaml.Node) ([]matrixCombination, string, error) {
+	if matrix.Kind != yaml.MappingNode {
+		return nil, "", fmt.Errorf("line %d: matrix must be a mapping", matrix.Line)
+	}
+
+	var axes []string
+	values := make(map[string][]string)
+	var include, exclude []map[string]string
+	nameTemplate := ""
+	for i := 0; i+1 < len(matrix.Content); i += 2 {
+		key, value := matrix.Content[i], matrix.Content[i+1]
+		switch key.Value {
+		case matrixName:
+			if value.Kind != yaml.ScalarNode {
+				return nil, "", fmt.Errorf("line %d: matrix name must be a string", value.Line)
+			}
+			nameTemplate = value.Value
+		case matrixInclude, matrixExclude:
+			entries, err := matrixEntries(key.Value, value)
+			if err != nil {
+				return nil, "", err
+			}
+			if key.Value == matrixInclude {
+				include = entries
+			} else {
+				exclude = entries
+			}
+		default:
+			if value.Kind != yaml.SequenceNode || len(value.Content) == 0 {
+				return nil, "", fmt.Errorf("line %d: matrix axis %q must be a non-empty list", value.Line, key.Value)
+			}
+			axes = append(axes, key.Value)
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, "", fmt.Errorf("line %d: matrix axis %q values must be scalars", item.Line, key.Value)
+				}
+				values[key.Value] = append(values[key.Value], item.Value)
+			}
+		}
+	}
+
+	// 展开前按笛卡尔积加 include 计算组合数上限，超过时直接拒绝，避免一个文件耗尽内存
+	if MatrixMaxCombinations > 0 {
+		total := 0
+		if len(axes) > 0 {
+			total = 1
+			for _, axis := range axes {
+				if total *= len(values[axis]); total > MatrixMaxCombinations {
+					break
+				}
+			}
+		}
+		if total += len(include); total > MatrixMaxCombinations {
+			return nil, "", fmt.Errorf("line %d: matrix has more than MATRIX_MAX_COMBINATIONS (%d) combinations before exclude", matrix.Line, MatrixMaxCombinations)
+		}
+	}
+
+	var combinations []matrixCombination
+	if len(axes) > 0 {
+		combinations = []matrixCombination{{values: map[string]string{}}}
+		for _, axis := range axes {
+			var next []matrixCombination
+			for _, c := range combinations {
+				for _, v := range values[axis] {
+					m := map[string]string{axis: v}
+					for k, existing := range c.values {
+						m[k] = existing
+					}
+					next = append(next, matrixCombination{keys: axes, values: m})
+				}
+			}
+			combinations = next
+		}
+	}
+
+	var result []matrixCombination
+	for _, c := range combinations {
+		if !matchesAny(c.values, exclude) {
+			result = append(result, c)
+		}
+	}
+	for _, entry := range include {
+		if matchesAny(entry, exclude) || containsCombination(result, entry) {
+			continue
+		}
+		result = append(result, matrixCombination{keys: matrixEntryKeys(axes, entry), values: entry})
+	}
+
+	if len(result) == 0 {
+		return nil, "", fmt.Errorf("line %d: matrix has no combinations", matrix.Line)
+	}
+	return result, nameTemplate, nil
+}
+
+// 解析 include/exclude 列表，每一项是维度到值的映射
+func matrixEntries(key string, node *yaml.Node) ([]map[string]string, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: matrix %s must be a list", node.Line, key)
+	}
+	var entries []map[string]string
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode || len(item.Content) == 0 {
+			return nil, fmt.Errorf("line %d: matrix %s entries must be non-empty mappings", item.Line, key)
+		}
+		entry := make(map[string]string)
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			if item.Content[i+1].Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: matrix %s values must be scalars", item.Content[i+1].Line, key)
+			}
+			entry[item.Content[i].Value] = item.Content[i+1].Value
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// include 的组合按维度顺序命名，维度之外的 key 排在后面
+func matrixEntryKeys(axes []string, entry map[string]string) []string {
+	var keys []string
+	for _, axis := range axes {
+		if _, ok := entry[axis]; ok {
+			keys = append(keys, axis)
+		}
+	}
+	var extra []string
+	for key := range entry {
+		if !slices.Contains(axes, key) {
+			extra = append(extra, key)
+		}
+	}
+	slices.Sort(extra)
+	return append(keys, extra...)
+}
+
+// entry 中的每个 key 都与组合相同时匹配
+func matchesAny(values map[string]string, entries []map[string]string) bool {
+	for _, entry := range entries {
+		matched := true
+		for k, v := range entry {
+			if values[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCombination(combinations []matrixCombination, entry map[string]string) bool {
+	for _, c := range combinations {
+		if len(c.values) == len(entry) && matchesAny(c.values, []map[string]string{entry}) {
+			return true
+		}
+	}
+	return false
+}
+
+// 替换 ${维度}，其他 ${...}（如 Woodpecker 的 ${CI_COMMIT_SHA}）保持不变
+func substituteMatrix(s string, c matrixCombination) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	for key, value := range c.values {
+		s = strings.ReplaceAll(s, "${"+key+"}", value)
+	}
+	return s
+}
+
+func substituteMatrixNode(node *yaml.Node, c matrixCombination) {
+	if node.Kind == yaml.ScalarNode {
+		node.Value = substituteMatrix(node.Value, c)
+	}
+	for _, child := range node.Content {
+		substituteMatrixNode(child, c)
+	}
+}
+
+// 展开错误转换为诊断信息，"line N: " 前缀作为行号
+func matrixDiagnostic(file string, err error) diagnostic {
+	message := err.Error()
+	line := 0
+	if rest, ok := strings.CutPrefix(message, "line "); ok {
+		if n, msg, ok := strings.Cut(rest, ": "); ok {
+			if parsed, err := strconv.Atoi(n); err == nil {
+				line, message = parsed, msg
+			}
+		}
+	}
+	return diagnostic{File: file, Line: line, Severity: severityError, Message: message}
+}
+
+// 深拷贝节点，每个组合单独替换
+func copyNode(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "cartesian product in axis order",
+			content: "matrix:\n  GO: [\"1.23\", \"1.24\"]\n  ARCH: [amd64, arm64]\nsteps:\n  - name: test\n    image: golang:${GO}\n    commands:\n      - echo ${ARCH} ${CI_COMMIT_SHA}\n",
+			want: map[string]string{
+				"test-1.23-amd64": "steps:\n  - name: test\n    image: golang:1.23\n    commands:\n      - echo amd64 ${CI_COMMIT_SHA}\n",
+				"test-1.23-arm64": "steps:\n  - name: test\n    image: golang:1.23\n    commands:\n      - echo arm64 ${CI_COMMIT_SHA}\n",
+				"test-1.24-amd64": "steps:\n  - name: test\n    image: golang:1.24\n    commands:\n      - echo amd64 ${CI_COMMIT_SHA}\n",
+				"test-1.24-arm64": "steps:\n  - name: test\n    image: golang:1.24\n    commands:\n      - echo arm64 ${CI_COMMIT_SHA}\n",
+			},
+		},
+		{
+			name:    "exclude, include and name template",
+			content: "matrix:\n  name: go${GO}/${ARCH}\n  GO: [\"1.23\", \"1.24\"]\n  ARCH: [amd64, arm64]\n  exclude:\n    - ARCH: arm64\n  include:\n    - GO: \"1.24\"\n      ARCH: amd64\n    - GO: \"1.25\"\n      ARCH: riscv64\nlabels:\n  platform: linux/${ARCH}\n",
+			want: map[string]string{
+				"test-go1.23-amd64":   "labels:\n  platform: linux/amd64\n",
+				"test-go1.24-amd64":   "labels:\n  platform: linux/amd64\n",
+				"test-go1.25-riscv64": "labels:\n  platform: linux/riscv64\n",
+			},
+		},
+		{
+			name:    "substituted values stay strings",
+			content: "matrix:\n  N: [\"1\"]\nvalue: ${N}\n",
+			want:    map[string]string{"test-1": "value: \"1\"\n"},
+		},
+		{
+			name:    "axis must be a list",
+			content: "matrix:\n  GO: \"1.24\"\nsteps: []\n",
+			wantErr: "line 2: matrix axis \"GO\" must be a non-empty list",
+		},
+		{
+			name:    "everything excluded",
+			content: "matrix:\n  GO: [\"1.24\"]\n  exclude:\n    - GO: \"1.24\"\nsteps: []\n",
+			wantErr: "matrix has no combinations",
+		},
+		{
+			name:    "duplicate names",
+			content: "matrix:\n  name: same\n  GO: [\"1.23\", \"1.24\"]\nsteps: []\n",
+			wantErr: "duplicate pipeline name \"test-same\"",
+		},
+		{
+			name:    "missing matrix key",
+			content: "steps: []\n",
+			wantErr: "no top-level \"matrix\" key",
+		},
+		{
+			name:    "combination limit",
+			content: "matrix:\n  A: [1, 2, 3, 4, 5]\n  B: [1, 2, 3, 4, 5]\n  C: [1, 2, 3, 4, 5]\nsteps: []\n",
+			wantErr: "more than MATRIX_MAX_COMBINATIONS (100)",
+		},
+		{
+			// 组合数在展开前检查，不会先构造 20^5 个组合
+			name:    "combination limit is checked before expansion",
+			content: "matrix:\n  A: [a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p, q, r, s, t]\n  B: [a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p, q, r, s, t]\n  C: [a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p, q, r, s, t]\n  D: [a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p, q, r, s, t]\n  E: [a, b, c, d, e, f, g, h, i, j, k, l, m, n, o, p, q, r, s, t]\nsteps: []\n",
+			wantErr: "more than MATRIX_MAX_COMBINATIONS (100)",
+		},
+		{
+			name:    "include counts towards the limit",
+			content: "matrix:\n  A: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]\n  B: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]\n  include:\n    - A: 11\n      B: 11\nsteps: []\n",
+			wantErr: "more than MATRIX_MAX_COMBINATIONS (100)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := GiteaFile{Name: "test.matrix.yml", Path: "app/main/test.matrix.yml", Content: tt.content}
+			configs, err := expandMatrix(file, tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expandMatrix() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandMatrix() error = %v", err)
+			}
+			if len(configs) != len(tt.want) {
+				t.Fatalf("got %d configs, want %d: %+v", len(configs), len(tt.want), configs)
+			}
+			for _, c := range configs {
+				if c.Data != tt.want[c.Name] {
+					t.Errorf("config %s =\n%s\nwant:\n%s", c.Name, c.Data, tt.want[c.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveConfigMatrix(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/app/main/test.matrix.yml.tmpl": "matrix:\n  GO: [\"1.23\", \"1.24\"]\nsteps:\n  - name: test\n    image: golang:${GO}\n    commands: [echo {{ .Repo.Name }}]\n",
+		"team/woodpeckerfiles/main/app/main/broken.matrix.yml":    "matrix:\n  GO: []\nsteps: []\n",
+		"team/woodpeckerfiles/main/app/main/build.yml":            "steps:\n  - name: build\n    image: alpine\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+	names := make(map[string]string)
+	for _, c := range res.Configs {
+		names[c.Name] = c.Data
+	}
+	if len(names) != 3 || !strings.Contains(names["test-1.23"], "golang:1.23") || !strings.Contains(names["test-1.24"], "echo app") || names["build"] == "" {
+		t.Errorf("unexpected configs: %+v", res.Configs)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].File != "app/main/broken.matrix.yml" {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
+
+func TestValidateTreeMatrix(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/test.matrix.yml":   "matrix:\n  GO: [\"1.24\"]\nsteps:\n  - name: test\n    image: golang:${GO}\n",
+		"app/main/broken.matrix.yml": "matrix:\n  GO: [\"1.24\"]\n  ARCH: amd64\nsteps:\n  - name: test\n    image: golang:${GO}\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].File != "app/main/broken.matrix.yml" || diags[0].Line != 3 || !strings.Contains(diags[0].Message, "ARCH") {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 删除标记：映射值 `DEBUG: !delete` 删除该 key，steps 列表项 `- !delete lint` 删除同名 step
+const deleteTag = "!delete"
+
+// 替换标记：`commands: !replace [...]` 直接替换而不是合并
+const replaceTag = "!replace"
+
+// 解析 OVERLAY_LAYERS，逗号分隔的路径模板，从通用到具体排列
+func overlayLayers() []string {
+	var layers []string
+	for _, layer := range strings.Split(OverlayLayers, ",") {
+		if layer = strings.TrimSpace(layer); layer != "" {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// 渲染各层的路径，规则与 WOODPECKER_CONFIG_YAMLPATH_TEMP 相同
+func renderLayers(data TemplateData) ([]string, error) {
+	var paths []string
+	for i, layer := range overlayLayers() {
+		p, err := renderTemplate(layer, data)
+		if err == nil {
+			err = checkRenderedValue(p, false)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("render overlay layer %d: %w", i+1, err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// 文件中是否有合并标记，没有标记且只有一层时原样返回内容
+func hasMergeMarkers(content string) bool {
+	return strings.Contains(content, deleteTag) || strings.Contains(content, replaceTag)
+}
+
+// 按顺序合并同名文件的各层内容，后面的层覆盖前面的层
+func mergeLayers(contents []string) (string, error) {
+	if len(contents) == 1 && !hasMergeMarkers(contents[0]) {
+		return contents[0], nil
+	}
+
+	var merged *yaml.Node
+	for _, content := range contents {
+		docs, err := decodeYAMLDocuments(content)
+		if err != nil {
+			return "", err
+		}
+		if len(docs) != 1 || len(docs[0].Content) == 0 {
+			return "", fmt.Errorf("overlay files must contain exactly one YAML document")
+		}
+		merged = mergeNodes(merged, docs[0].Content[0])
+	}
+	if merged == nil {
+		merged = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	return encodeYAMLDocuments([]*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{merged}}})
+}
+
+// 深度合并两个节点，返回 nil 表示删除：
+// 映射按 key 递归合并；元素都带 name 的列表按 name 合并，新元素追加到末尾；
+// 其他列表和标量由 overlay 替换
+func mergeNodes(base, overlay *yaml.Node) *yaml.Node {
+	switch {
+	case overlay.Tag == deleteTag:
+		return nil
+	case overlay.Tag == replaceTag, base == nil:
+		return stripMergeMarkers(overlay)
+	case base.Kind == yaml.MappingNode && overlay.Kind == yaml.MappingNode:
+		merged := &yaml.Node{Kind: yaml.MappingNode, Tag: base.Tag, Style: base.Style, Content: append([]*yaml.Node(nil), base.Content...)}
+		for i := 0; i+1 < len(overlay.Content); i += 2 {
+			key, value := overlay.Content[i], overlay.Content[i+1]
+			if value = mergeNodes(mappingNode(merged, key.Value), value); value == nil {
+				deleteMappingKey(merged, key.Value)
+			} else {
+				setMappingValue(merged, key, value)
+			}
+		}
+		return merged
+	case base.Kind == yaml.SequenceNode && overlay.Kind == yaml.SequenceNode && isNamedList(base, false) && isNamedList(overlay, true):
+		return mergeNamedLists(base, overlay)
+	default:
+		return stripMergeMarkers(overlay)
+	}
+}
+
+// 按 name 合并两个列表，overlay 中的 `!delete name` 删除对应元素
+func mergeNamedLists(base, overlay *yaml.Node) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.SequenceNode, Tag: base.Tag, Style: base.Style, Content: append([]*yaml.Node(nil), base.Content...)}
+	for _, item := range overlay.Content {
+		name := item.Value
+		if item.Kind == yaml.MappingNode {
+			name = mappingNode(item, "name").Value
+		}
+		index := -1
+		for i, existing := range merged.Content {
+			if mappingNode(existing, "name").Value == name {
+				index = i
+				break
+			}
+		}
+		switch {
+		case item.Tag == deleteTag:
+			if index >= 0 {
+				merged.Content = append(merged.Content[:index], merged.Content[index+1:]...)
+			}
+		case index >= 0:
+			merged.Content[index] = mergeNodes(merged.Content[index], item)
+		default:
+			merged.Content = append(merged.Content, stripMergeMarkers(item))
+		}
+	}
+	return merged
+}
+
+// 列表的元素是否都是带 name 的映射；overlay 中还允许 `!delete name` 元素
+func isNamedList(node *yaml.Node, allowDelete bool) bool {
+	if len(node.Content) == 0 {
+		return allowDelete
+	}
+	for _, item := range node.Content {
+		if allowDelete && item.Kind == yaml.ScalarNode && item.Tag == deleteTag {
+			continue
+		}
+		if item.Kind != yaml.MappingNode || item.Tag == replaceTag {
+			return false
+		}
+		if name := mappingNode(item, "name"); name == nil || name.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+func deleteMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// 去掉没有可合并对象的标记：`!delete` 的值和元素直接丢弃，`!replace` 只去掉标签
+func stripMergeMarkers(node *yaml.Node) *yaml.Node {
+	if node.Tag == replaceTag {
+		node.Tag = ""
+	}
+	var content []*yaml.Node
+	for i := 0; i < len(node.Content); i++ {
+		child := node.Content[i]
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			if i+1 < len(node.Content) && node.Content[i+1].Tag == deleteTag {
+				i++
+				continue
+			}
+			content = append(content, child)
+			continue
+		}
+		if child.Tag == deleteTag {
+			continue
+		}
+		content = append(content, stripMergeMarkers(child))
+	}
+	node.Content = content
+	return node
+}
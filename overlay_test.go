@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMergeLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents []string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "single layer is returned verbatim",
+			contents: []string{"# keep\nsteps:\n  - name: build\n"},
+			want:     "# keep\nsteps:\n  - name: build\n",
+		},
+		{
+			name: "maps merge recursively",
+			contents: []string{
+				"when:\n  event: push\nclone:\n  git:\n    image: git\n    depth: 1\n",
+				"clone:\n  git:\n    depth: 50\n",
+			},
+			want: "when:\n  event: push\nclone:\n  git:\n    image: git\n    depth: 50\n",
+		},
+		{
+			name: "steps merge by name and new steps are appended",
+			contents: []string{
+				"steps:\n  - name: test\n    image: golang:1.23\n    commands:\n      - go test ./...\n  - name: build\n    image: golang:1.23\n",
+				"steps:\n  - name: build\n    image: golang:1.24\n  - name: publish\n    image: plugins/docker\n",
+			},
+			want: "steps:\n  - name: test\n    image: golang:1.23\n    commands:\n      - go test ./...\n  - name: build\n    image: golang:1.24\n  - name: publish\n    image: plugins/docker\n",
+		},
+		{
+			name: "other lists are replaced",
+			contents: []string{
+				"steps:\n  - name: test\n    commands:\n      - go vet ./...\n      - go test ./...\n",
+				"steps:\n  - name: test\n    commands:\n      - go test -race ./...\n",
+			},
+			want: "steps:\n  - name: test\n    commands:\n      - go test -race ./...\n",
+		},
+		{
+			name: "delete markers remove keys and named steps",
+			contents: []string{
+				"steps:\n  - name: lint\n    image: golangci\n  - name: test\n    image: golang\n    environment:\n      CGO_ENABLED: 0\n      DEBUG: 1\nservices:\n  - name: db\n    image: postgres\n",
+				"steps:\n  - !delete lint\n  - name: test\n    environment:\n      DEBUG: !delete\nservices: !delete\n",
+			},
+			want: "steps:\n  - name: test\n    image: golang\n    environment:\n      CGO_ENABLED: 0\n",
+		},
+		{
+			name: "replace marker skips merging",
+			contents: []string{
+				"when:\n  event: push\n  branch: main\n",
+				"when: !replace\n  event: tag\n",
+			},
+			want: "when:\n  event: tag\n",
+		},
+		{
+			name:     "markers without a base are dropped",
+			contents: []string{"steps:\n  - !delete lint\n  - name: build\n    environment:\n      DEBUG: !delete\n"},
+			want:     "steps:\n  - name: build\n    environment: {}\n",
+		},
+		{
+			name:     "multiple documents are rejected",
+			contents: []string{"steps: []\n---\nsteps: []\n", "steps: []\n"},
+			wantErr:  "exactly one YAML document",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeLayers(tt.contents)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mergeLayers() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeLayers() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("mergeLayers() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigOverlayLayers(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/_defaults/build.yml":         "steps:\n  - name: test\n    image: golang:1.23\n  - name: build\n    image: golang:1.23\n",
+		"team/woodpeckerfiles/main/_defaults/lint.yml":          "steps:\n  - name: lint\n    image: golangci\n",
+		"team/woodpeckerfiles/main/app/build.yml":               "steps:\n  - name: build\n    image: golang:1.24\n",
+		"team/woodpeckerfiles/main/app/release/build.yml":       "steps:\n  - !delete test\n",
+		"team/woodpeckerfiles/main/app/release/broken.yml":      "steps: [\n",
+		"team/woodpeckerfiles/main/app/release/deploy.yml.tmpl": "steps:\n  - name: deploy\n    image: alpine\n    commands:\n      - echo {{ .Config.Path }}\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+	previous := []string{OverlayLayers, BranchTemplate}
+	OverlayLayers = "_defaults, {{ .Repo.Name }}, {{ .Repo.Name }}/{{ .Pipeline.Branch }}"
+	BranchTemplate = "main"
+	t.Cleanup(func() { OverlayLayers, BranchTemplate = previous[0], previous[1] })
+
+	tests := []struct {
+		branch  string
+		configs map[string]string
+		errors  []string
+	}{
+		{
+			branch: "release",
+			configs: map[string]string{
+				"build":  "steps:\n  - name: build\n    image: golang:1.24\n",
+				"lint":   "steps:\n  - name: lint\n    image: golangci\n",
+				"deploy": "echo app/release",
+				// 与单层一样，非法 YAML 照常返回并报告错误
+				"broken": "steps: [",
+			},
+			errors: []string{"app/release/broken.yml"},
+		},
+		{
+			// 不存在的层被跳过
+			branch: "main",
+			configs: map[string]string{
+				"build": "steps:\n  - name: test\n    image: golang:1.23\n  - name: build\n    image: golang:1.24\n",
+				"lint":  "steps:\n  - name: lint\n    image: golangci\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			req := ConfigRequest{
+				Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+				Pipeline: PipelineInfo{Branch: tt.branch},
+			}
+			res, err := resolveConfig(context.Background(), req)
+			if err != nil {
+				t.Fatalf("resolveConfig() error = %v", err)
+			}
+			if len(res.Layers) != 3 || res.Layers[2] != "app/"+tt.branch {
+				t.Errorf("unexpected layers: %v", res.Layers)
+			}
+			if len(res.Configs) != len(tt.configs) {
+				t.Errorf("got %d configs, want %d: %+v", len(res.Configs), len(tt.configs), res.Configs)
+			}
+			for _, c := range res.Configs {
+				if want, ok := tt.configs[c.Name]; !ok || !strings.Contains(c.Data, want) {
+					t.Errorf("config %s =\n%s\nwant:\n%s", c.Name, c.Data, want)
+				}
+			}
+			if len(res.Errors) != len(tt.errors) {
+				t.Fatalf("unexpected errors: %+v", res.Errors)
+			}
+			for i, e := range res.Errors {
+				if e.File != tt.errors[i] {
+					t.Errorf("error for %s, want %s", e.File, tt.errors[i])
+				}
+			}
+		})
+	}
+
+	// 所有层都不存在时报错
+	req := ConfigRequest{Repo: RepoInfo{Owner: "team", Name: "app"}, Pipeline: PipelineInfo{Branch: "main"}}
+	OverlayLayers = "missing, {{ .Repo.Name }}/missing"
+	if _, err := resolveConfig(context.Background(), req); err == nil {
+		t.Error("expected error when no layer exists")
+	}
+
+	// 不存在以外的错误不能跳过，否则会返回缺少覆盖的配置
+	OverlayLayers = "_defaults, {{ .Repo.Name }}/build.yml"
+	if _, err := resolveConfig(context.Background(), req); err == nil || errors.Is(err, errConfigNotFound) {
+		t.Errorf("expected layer read error, got %v", err)
+	}
+}
+
+func TestValidateTreeOverlayLayers(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"_defaults/build.yml":  "steps:\n  - name: lint\n    image: golangci\n  - name: build\n    image: golang:1.23\n",
+		"_defaults/deploy.yml": "steps:\n  - name: deploy\n    image: alpine\n",
+		"app/build.yml":        "steps:\n  - !delete lint\n  - name: build\n    commands:\n      - go build\n",
+		"app/main/deploy.yml":  "steps:\n  - name: deploy\n    privileged: true\n",
+		"app/main/extra.yml":   "steps:\n  - name: extra\n",
+	})
+	previous := OverlayLayers
+	OverlayLayers = "_defaults, {{ .Repo.Name }}, {{ .Repo.Name }}/{{ .Pipeline.Branch }}"
+	t.Cleanup(func() { OverlayLayers = previous })
+
+	targets := []validateTarget{{Repo: "team/app", Branch: "main"}, {Repo: "team/other", Branch: "main"}}
+	diags, err := validateTree(root, targets, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 覆盖层中的片段不单独要求 image，合并后的结果照常校验；只有 _defaults 的仓库不报路径不存在
+	want := []string{
+		`team/app@main: error: merged deploy: step deploy: privileged mode is not allowed`,
+		`team/app@main: error: merged extra: step extra: missing "image"`,
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %v", len(diags), len(want), diags)
+	}
+	for i, d := range diags {
+		if d.String() != want[i] {
+			t.Errorf("diagnostic[%d] = %q, want %q", i, d.String(), want[i])
+		}
+	}
+
+	// 所有层都不存在时报错
+	OverlayLayers = "missing, {{ .Repo.Name }}"
+	diags = validateTargetPath(root, validateTarget{Repo: "team/nothing", Branch: "main"}, nil, nil, defaultValidatePolicy)
+	if len(diags) != 1 || diags[0].Message != `overlay layers "missing", "nothing" do not exist` {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestResolveConfigOverlayGeneratorFailure(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/_defaults/build.jsonnet": "[{ steps: [{ name: 'a', image: 'alpine' }] }, { steps: [{ name: 'b', image: 'alpine' }] }]\n",
+		"team/woodpeckerfiles/main/_defaults/lint.yml":      "steps:\n  - name: lint\n    image: golangci\n",
+		"team/woodpeckerfiles/main/app/build.jsonnet":       "error 'boom'\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+	previous := []string{OverlayLayers, BranchTemplate}
+	OverlayLayers = "_defaults, {{ .Repo.Name }}"
+	BranchTemplate = "main"
+	t.Cleanup(func() { OverlayLayers, BranchTemplate = previous[0], previous[1] })
+
+	req := ConfigRequest{Repo: RepoInfo{Owner: "team", Name: "app"}, Pipeline: PipelineInfo{Branch: "main"}}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+
+	// 生成器在任意一层失败时，其他层生成的 build-1、build-2 也不能返回
+	if len(res.Configs) != 1 || res.Configs[0].Name != "lint" {
+		t.Errorf("unexpected configs: %+v", res.Configs)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].File != "app/build.jsonnet" {
+		t.Errorf("unexpected errors: %+v", res.Errors)
+	}
+}
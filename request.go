@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Woodpecker 请求结构（config extension），字段与 Woodpecker 的 model.Repo / model.Pipeline / model.Netrc 对应
+type ConfigRequest struct {
+	Repo     RepoInfo     `json:"repo"`
+	Pipeline PipelineInfo `json:"pipeline"`
+	Netrc    NetrcInfo    `json:"netrc"`
+	Config   ConfigInfo   `json:"config"`
+}
+
+type RepoInfo struct {
+	ID            int64       `json:"id"`
+	ForgeID       int64       `json:"forge_id"`
+	ForgeRemoteID string      `json:"forge_remote_id"`
+	OrgID         int64       `json:"org_id"`
+	Name          string      `json:"name"`
+	Owner         string      `json:"owner"`
+	Namespace     string      `json:"-"` // 别名，用于模板，从 Owner 复制值
+	FullName      string      `json:"full_name"`
+	Avatar        string      `json:"avatar_url"`
+	ForgeURL      string      `json:"forge_url"`
+	CloneURL      string      `json:"clone_url"`
+	CloneSSH      string      `json:"clone_url_ssh"`
+	Branch        string      `json:"default_branch"`
+	SCM           string      `json:"scm"`
+	Timeout       int64       `json:"timeout"`
+	Visibility    string      `json:"visibility"` // public/private/internal
+	Private       bool        `json:"private"`
+	Trusted       RepoTrusted `json:"trusted"`
+
+	Gated           bool   `json:"gated"`            // 2.x，3.x 改为 require_approval
+	RequireApproval string `json:"require_approval"` // forks/pull_requests/all_events/none
+	Active          bool   `json:"active"`
+	PREnabled       bool   `json:"pr_enabled"`
+	AllowPull       bool   `json:"allow_pr"`
+	AllowDeploy     bool   `json:"allow_deploy"`
+	ConfigFile      string `json:"config_file"`
+
+	CancelPreviousPipelineEvents []string `json:"cancel_previous_pipeline_events"`
+	NetrcTrusted                 []string `json:"netrc_trusted"`
+}
+
+// 仓库的受信任设置：3.x 为对象，2.x 为单个布尔值
+type RepoTrusted struct {
+	Network  bool `json:"network"`
+	Volumes  bool `json:"volumes"`
+	Security bool `json:"security"`
+}
+
+func (t *RepoTrusted) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] != '{' {
+		var trusted bool
+		if err := json.Unmarshal(data, &trusted); err != nil {
+			return err
+		}
+		*t = RepoTrusted{Network: trusted, Volumes: trusted, Security: trusted}
+		return nil
+	}
+	type plain RepoTrusted
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+type PipelineInfo struct {
+	ID          int64    `json:"id"`
+	Number      int64    `json:"number"`
+	Parent      int64    `json:"parent"`
+	Event       string   `json:"event"` // push/pull_request/pull_request_closed/pull_request_metadata/tag/release/deployment/cron/manual
+	EventReason []string `json:"event_reason"`
+	Status      string   `json:"status"`
+	Created     int64    `json:"created"`
+	Updated     int64    `json:"updated"`
+	Started     int64    `json:"started"`
+	Finished    int64    `json:"finished"`
+	DeployTo    string   `json:"deploy_to"`
+	DeployTask  string   `json:"deploy_task"`
+	Cron        string   `json:"cron"`
+	Commit      string   `json:"commit"`
+	Branch      string   `json:"branch"`
+	Ref         string   `json:"ref"`
+	Refspec     string   `json:"refspec"` // PR 为 "源分支:目标分支"
+	Title       string   `json:"title"`
+	Message     string   `json:"message"`
+	Timestamp   int64    `json:"timestamp"`
+	Sender      string   `json:"sender"`
+	Author      string   `json:"author"`
+	Avatar      string   `json:"author_avatar"`
+	Email       string   `json:"author_email"`
+	ForgeURL    string   `json:"forge_url"`
+	Reviewer    string   `json:"reviewed_by"`
+	Reviewed    int64    `json:"reviewed"`
+
+	ChangedFiles         []string          `json:"changed_files"`
+	Variables            map[string]string `json:"variables"`
+	PullRequestLabels    []string          `json:"pr_labels"`
+	PullRequestMilestone string            `json:"pr_milestone"`
+	IsPrerelease         bool              `json:"is_prerelease"`
+	FromFork             bool              `json:"from_fork"`
+}
+
+// 克隆凭据，Type 为 forge 类型（gitea/forgejo/github/gitlab/bitbucket/...）
+type NetrcInfo struct {
+	Machine  string `json:"machine"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Type     string `json:"type"`
+}
+
+type ConfigInfo struct {
+	Data string `json:"data"`
+}
+
+// 模板数据
+type TemplateData struct {
+	Repo     RepoInfo
+	Pipeline PipelineInfo
+	Netrc    NetrcInfo
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadWoodpeckerPayload(t *testing.T, name string) ConfigRequest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "woodpecker", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req ConfigRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	return req
+}
+
+func TestWoodpeckerPayloadTemplates(t *testing.T) {
+	tests := []struct {
+		payload  string
+		template string
+		want     string
+	}{
+		{"v3-push.json", "{{ .Pipeline.Event }}/{{ .Pipeline.Branch }}", "push/main"},
+		{"v3-push.json", "{{ .Pipeline.Author }} <{{ .Pipeline.Email }}>", "mlin <mlin@example.com>"},
+		{"v3-push.json", "{{ index .Pipeline.ChangedFiles 2 }}", "go.mod"},
+		{"v3-push.json", "{{ .Repo.Visibility }} {{ .Repo.Private }} {{ .Repo.Trusted.Volumes }}", "internal true true"},
+		{"v3-push.json", "{{ .Netrc.Type }}@{{ .Netrc.Machine }}", "forgejo@git.example.com"},
+		{"v3-pull-request.json", "{{ .Pipeline.Refspec }}", "feature/retry-budget:main"},
+		{"v3-pull-request.json", "{{ range .Pipeline.PullRequestLabels }}{{ . }};{{ end }}", "enhancement;needs-review;"},
+		{"v3-pull-request.json", "{{ .Pipeline.FromFork }} {{ .Pipeline.PullRequestMilestone }}", "true v2.4"},
+		{"v3-deployment.json", "deploy/{{ .Pipeline.DeployTo }}/{{ .Pipeline.DeployTask }}", "deploy/production/migrate"},
+		{"v3-deployment.json", "{{ .Pipeline.Variables.RELEASE_CHANNEL }}", "stable"},
+		{"v2-tag.json", "{{ .Pipeline.Event }} {{ .Pipeline.Ref }}", "tag refs/tags/v1.8.0"},
+		{"v2-tag.json", "{{ .Repo.Trusted.Network }} {{ .Repo.Gated }} {{ .Repo.SCM }}", "true false git"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload+" "+tt.template, func(t *testing.T) {
+			req := loadWoodpeckerPayload(t, tt.payload)
+			got, err := renderTemplate(tt.template, TemplateData{Repo: req.Repo, Pipeline: req.Pipeline, Netrc: req.Netrc})
+			if err != nil {
+				t.Fatalf("renderTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoTrustedUnmarshal(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    RepoTrusted
+		wantErr bool
+	}{
+		{`true`, RepoTrusted{Network: true, Volumes: true, Security: true}, false},
+		{`false`, RepoTrusted{}, false},
+		{`{"network":true}`, RepoTrusted{Network: true}, false},
+		{`"yes"`, RepoTrusted{}, true},
+	}
+	for _, tt := range tests {
+		var got RepoTrusted
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("unmarshal %s = %+v, %v; want %+v, error %v", tt.data, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+)
+
+// 一次配置解析的结果，HTTP 接口和 render 子命令共用
+type resolution struct {
+	Namespace string       `json:"namespace"`
+	Repo      string       `json:"repo"`
+	Branch    string       `json:"branch"`
+	Path      string       `json:"path"`
+	Layers    []string     `json:"layers,omitempty"`
+	Configs   []ConfigFile `json:"configs"`
+	Errors    []fileError  `json:"errors,omitempty"`
+}
+
+// 单个配置文件的问题（不影响其他文件）
+type fileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// 渲染模板、获取配置文件并校验，返回的 resolution 在出错时也包含已解析的位置
+func resolveConfig(ctx context.Context, req ConfigRequest) (*resolution, error) {
+	// 复制 Owner 到 Namespace（用于模板兼容性）
+	req.Repo.Namespace = req.Repo.Owner
+
+	// 准备模板数据
+	data := TemplateData{
+		Repo:     req.Repo,
+		Pipeline: req.Pipeline,
+		Netrc:    req.Netrc,
+	}
+	// 克隆密码不暴露给模板，避免渲染结果出现在日志和路径中
+	data.Netrc.Password = ""
+
+	res := &resolution{}
+
+	// 渲染模板
+	_, span := startSpan(ctx, "render templates")
+	namespace, repoName, branch, path, err := renderLocation(data)
+	if err != nil {
+		endSpan(span, err)
+		return res, err
+	}
+	span.SetAttributes(resolvedAttributes(namespace, repoName, branch, path)...)
+	endSpan(span, nil)
+
+	res.Namespace, res.Repo, res.Branch, res.Path = namespace, repoName, branch, path
+	slog.DebugContext(ctx, "resolved values",
+		"namespace", namespace, "repo", repoName, "branch", branch, "path", path)
+
+	// 未配置 OVERLAY_LAYERS 时只有 path 一层
+	layers := []string{path}
+	if len(overlayLayers()) > 0 {
+		if layers, err = renderLayers(data); err != nil {
+			return res, err
+		}
+		res.Layers = layers
+		slog.DebugContext(ctx, "resolved overlay layers", "layers", layers)
+	}
+
+	// 同名文件（去掉后缀后）的各层内容，按第一次出现的顺序输出
+	type configEntry struct {
+		name     string
+		file     string
+		contents []string
+		sources  []string
+	}
+	var entries []*configEntry
+	// 处理失败的源文件；分层时按去掉后缀的文件名记录，任意一层失败时该文件在所有层生成的 pipeline 都跳过
+	failed := make(map[string]bool)
+	byName := make(map[string]*configEntry)
+	entryFor := func(name string) *configEntry {
+		entry := byName[name]
+		if entry == nil || res.Layers == nil {
+			entry = &configEntry{name: name}
+			entries = append(entries, entry)
+			byName[name] = entry
+		}
+		return entry
+	}
+
+	loader := remoteIncludeLoader(ctx, namespace, repoName, branch)
+	var fetchErr error
+	fetched := false
+	for _, layer := range layers {
+		files, err := fetchFilesFromGitServer(ctx, namespace, repoName, branch, layer)
+		if err != nil {
+			// 不存在的层直接跳过，其他错误（认证、超时、5xx）直接返回，避免丢失覆盖；
+			// 所有层都不存在时才报错
+			if res.Layers == nil || !errors.Is(err, errConfigNotFound) {
+				return res, err
+			}
+			slog.DebugContext(ctx, "skipping overlay layer", "path", layer, "error", err)
+			if fetchErr == nil {
+				fetchErr = err
+			}
+			continue
+		}
+		fetched = true
+
+		slog.DebugContext(ctx, "found config files", "path", layer, "count", len(files))
+
+		location := fileTemplateLocation{Namespace: namespace, Repo: repoName, Branch: branch, Path: layer}
+		includes := newIncludeResolver(loader, func(f GiteaFile) (string, error) {
+			return renderFileTemplate(f, data, location)
+		})
+		for _, file := range files {
+			// Jsonnet 库只用于 import
+			if isJsonnetLibrary(file.Name) {
+				continue
+			}
+
+			source := file.Path
+			if res.Layers != nil {
+				source = pipelineName(file.Name)
+			}
+			configs, err := prepareConfigFile(ctx, file, data, location, includes)
+			if err != nil {
+				res.Errors = append(res.Errors, fileError{File: file.Path, Error: err.Error()})
+				failed[source] = true
+				continue
+			}
+			for _, config := range configs {
+				entry := entryFor(config.Name)
+				entry.file = file.Path
+				entry.contents = append(entry.contents, config.Data)
+				entry.sources = append(entry.sources, source)
+			}
+		}
+	}
+	if !fetched {
+		return res, fetchErr
+	}
+
+	for _, entry := range entries {
+		// 任意一层失败时整个文件跳过，避免返回缺少覆盖的配置
+		if slices.ContainsFunc(entry.sources, func(source string) bool { return failed[source] }) {
+			continue
+		}
+		content := entry.contents[0]
+		if res.Layers != nil {
+			_, mergeSpan := startSpan(ctx, "merge overlays", attribute.String("config.file", entry.name), attribute.Int("config.layers", len(entry.contents)))
+			content, err = mergeLayers(entry.contents)
+			endSpan(mergeSpan, err)
+			if err != nil {
+				slog.WarnContext(ctx, "overlay merge failed", "file", entry.name, "error", err)
+				res.Errors = append(res.Errors, fileError{File: entry.file, Error: err.Error()})
+				continue
+			}
+		}
+
+		// SDK 已经返回原始 YAML 内容，直接使用
+		// 验证是否是有效的 YAML
+		_, validateSpan := startSpan(ctx, "validate yaml", attribute.String("config.file", entry.name))
+		var testData interface{}
+		if err := yaml.Unmarshal([]byte(content), &testData); err != nil {
+			slog.WarnContext(ctx, "yaml validation failed", "file", entry.file, "error", err)
+			res.Errors = append(res.Errors, fileError{File: entry.file, Error: err.Error()})
+			endSpan(validateSpan, err)
+		} else {
+			slog.DebugContext(ctx, "yaml validation passed", "file", entry.file, "bytes", len(content))
+			endSpan(validateSpan, nil)
+		}
+
+		res.Configs = append(res.Configs, ConfigFile{
+			Name: entry.name,
+			Data: content,
+		})
+	}
+
+	return res, nil
+}
+
+// 渲染模板文件并展开 include，Jsonnet、Starlark、CUE 文件执行后可能生成多个 pipeline
+func prepareConfigFile(ctx context.Context, file GiteaFile, data TemplateData, location fileTemplateLocation, includes *includeResolver) ([]ConfigFile, error) {
+	if isJsonnetFile(file.Name) {
+		_, jsonnetSpan := startSpan(ctx, "evaluate jsonnet", attribute.String("config.file", file.Name))
+		configs, err := evaluateJsonnet(file, data, location, includes.load)
+		endSpan(jsonnetSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "jsonnet evaluation failed", "file", file.Name, "error", err)
+			return nil, err
+		}
+		return configs, nil
+	}
+	if isStarlarkFile(file.Name) {
+		starlarkCtx, starlarkSpan := startSpan(ctx, "evaluate starlark", attribute.String("config.file", file.Name))
+		configs, err := evaluateStarlark(starlarkCtx, file, data, location, includes.load)
+		endSpan(starlarkSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "starlark evaluation failed", "file", file.Name, "error", err)
+			return nil, err
+		}
+		return configs, nil
+	}
+	if isCUEFile(file.Name) {
+		_, cueSpan := startSpan(ctx, "evaluate cue", attribute.String("config.file", file.Name))
+		configs, err := evaluateCUE(file, data, location)
+		endSpan(cueSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "cue evaluation failed", "file", file.Name, "error", err)
+			return nil, err
+		}
+		return configs, nil
+	}
+
+	// 模板文件先渲染，失败时只跳过该文件
+	if isFileTemplate(file.Name, file.Content) {
+		_, renderSpan := startSpan(ctx, "render file template", attribute.String("config.file", file.Name))
+		content, err := renderFileTemplate(file, data, location)
+		endSpan(renderSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "file template rendering failed", "file", file.Name, "error", err)
+			return nil, err
+		}
+		file.Content = content
+	}
+
+	// 展开 include，失败时只跳过该文件
+	if mayInclude(file.Content) {
+		_, includeSpan := startSpan(ctx, "resolve includes", attribute.String("config.file", file.Name))
+		content, err := includes.expand(file)
+		endSpan(includeSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "include resolution failed", "file", file.Name, "error", err)
+			return nil, err
+		}
+		file.Content = content
+	}
+
+	// 矩阵文件展开为每个组合一个 pipeline，失败时只跳过该文件
+	if isMatrixFile(file.Name) {
+		_, matrixSpan := startSpan(ctx, "expand matrix", attribute.String("config.file", file.Name))
+		configs, err := expandMatrix(file, file.Content)
+		endSpan(matrixSpan, err)
+		if err != nil {
+			slog.WarnContext(ctx, "matrix expansion failed", "file", file.Name, "error", err)
+			return nil, fmt.Errorf("matrix: %w", err)
+		}
+		return configs, nil
+	}
+	return []ConfigFile{{Name: pipelineName(file.Name), Data: file.Content}}, nil
+}
+
+// Jsonnet、Starlark、CUE 可用的请求数据，字段名与 Woodpecker 请求中的 JSON 字段相同
+func generatorInput(data TemplateData, location fileTemplateLocation) (map[string]interface{}, error) {
+	vars, err := parseTemplateVars(TemplateVars)
+	if err != nil {
+		return nil, err
+	}
+	input := make(map[string]interface{})
+	for key, value := range map[string]interface{}{
+		"repo":     data.Repo,
+		"pipeline": data.Pipeline,
+		"netrc":    data.Netrc,
+		"vars":     vars,
+		"config":   location,
+	} {
+		// 经过一次 JSON 转换，得到与请求一致的字段名
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		input[key] = v
+	}
+	return input, nil
+}
+
+// 生成器的输出：对象生成一个 pipeline，对象数组中的每个元素各生成一个（名称加 -1、-2 后缀）
+func generatedConfigs(file GiteaFile, result interface{}) ([]ConfigFile, error) {
+	name := pipelineName(file.Name)
+	docs, ok := result.([]interface{})
+	if !ok {
+		docs = []interface{}{result}
+	} else if len(docs) == 0 {
+		return nil, fmt.Errorf("%s produced an empty list", file.Path)
+	}
+
+	var configs []ConfigFile
+	for i, doc := range docs {
+		if _, ok := doc.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s must produce an object or a list of objects", file.Path)
+		}
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		configName := name
+		if len(docs) > 1 {
+			configName = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		configs = append(configs, ConfigFile{Name: configName, Data: string(b)})
+	}
+	return configs, nil
+}
+
+// 生成器的错误带有多行调用栈（file:line:col），合并为一行便于放进 errors
+func singleLineError(err error) string {
+	var parts []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			parts = append(parts, line)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 从文件读取的凭据（Docker/Kubernetes secret），文件变化后自动重新读取，轮换无需重启
+type secretFile struct {
+	name string
+	path string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+	loaded  bool
+}
+
+var (
+	tokenFile         = &secretFile{name: "TOKEN_FILE", path: TokenFile}
+	giteaTokenFile    = &secretFile{name: "GITEA_TOKEN_FILE", path: GiteaTokenFile}
+	adminTokenFile    = &secretFile{name: "ADMIN_TOKEN_FILE", path: AdminTokenFile}
+	webhookSecretFile = &secretFile{name: "GIT_WEBHOOK_SECRET_FILE", path: GitWebhookSecretFile}
+	githubAppKeyFile  = &secretFile{name: "GITHUB_APP_PRIVATE_KEY_FILE", path: GitHubAppPrivateKeyFile}
+)
+
+// 返回文件中的凭据；每次调用只做一次 stat，修改时间或大小变化时才重新读取。
+// 读取失败时保留上一次的值，避免轮换过程中的短暂缺失导致请求失败
+func (f *secretFile) get() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Kubernetes 通过替换 ..data 符号链接更新 secret，os.Stat 跟随链接即可感知
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if !f.loaded {
+			slog.Error("failed to read credential file", "name", f.name, "error", err)
+		}
+		return f.value
+	}
+	if f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		slog.Error("failed to read credential file", "name", f.name, "error", err)
+		return f.value
+	}
+	value := strings.TrimSpace(string(data))
+	registerSecret(value)
+	if f.loaded && value != f.value {
+		slog.Info("credential file reloaded", "name", f.name)
+	}
+	f.value, f.modTime, f.size, f.loaded = value, info.ModTime(), info.Size(), true
+	return value
+}
+
+// 文件优先，否则使用环境变量中的值
+func secretValue(f *secretFile, env string) string {
+	if f.path != "" {
+		return f.get()
+	}
+	return env
+}
+
+// 当前的访问令牌：CREDENTIALS_FILE 中匹配的令牌优先，其次 TOKEN / TOKEN_FILE
+func currentToken(ctx context.Context) string {
+	if token := mappedToken(ctx); token != "" {
+		return token
+	}
+	return secretValue(tokenFile, Token)
+}
+
+// Gitea 系令牌：匹配的令牌、GITEA_TOKEN_FILE、GITEA_TOKEN，都未设置时沿用 TOKEN
+func currentGiteaToken(ctx context.Context) string {
+	if token := mappedToken(ctx); token != "" {
+		return token
+	}
+	if giteaTokenFile.path != "" {
+		return giteaTokenFile.get()
+	}
+	if GiteaToken != "" {
+		return GiteaToken
+	}
+	return currentToken(ctx)
+}
+
+func currentAdminToken() string {
+	return secretValue(adminTokenFile, AdminToken)
+}
+
+func currentWebhookSecret() string {
+	return secretValue(webhookSecretFile, GitWebhookSecret)
+}
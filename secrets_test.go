@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f := &secretFile{name: "TOKEN_FILE", path: path}
+	if got := f.get(); got != "first-token" {
+		t.Fatalf("get() = %q, want first-token", got)
+	}
+
+	// 轮换后长度相同，依靠修改时间感知变化
+	if err := os.WriteFile(path, []byte("other-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.get(); got != "other-token" {
+		t.Fatalf("get() after rotation = %q, want other-token", got)
+	}
+
+	// 文件暂时缺失时保留上一次的值
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.get(); got != "other-token" {
+		t.Errorf("get() with missing file = %q, want previous value", got)
+	}
+}
+
+func TestCurrentTokenPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gitea-token")
+	if err := os.WriteFile(path, []byte("from-file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("from-token-file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	previousToken, previousGitea := Token, GiteaToken
+	previousFiles := []*secretFile{tokenFile, giteaTokenFile}
+	t.Cleanup(func() {
+		Token, GiteaToken = previousToken, previousGitea
+		tokenFile, giteaTokenFile = previousFiles[0], previousFiles[1]
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		giteaToken string
+		tokenFile  string
+		giteaFile  string
+		want       string
+		wantToken  string
+	}{
+		{"falls back to TOKEN", "env-token", "", "", "", "env-token", "env-token"},
+		{"falls back to TOKEN_FILE over TOKEN", "env-token", "", tokenPath, "", "from-token-file", "from-token-file"},
+		{"GITEA_TOKEN wins over TOKEN", "env-token", "gitea-token", tokenPath, "", "gitea-token", "from-token-file"},
+		{"GITEA_TOKEN_FILE wins over env", "env-token", "gitea-token", "", path, "from-file", "env-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Token, GiteaToken = tt.token, tt.giteaToken
+			tokenFile = &secretFile{name: "TOKEN_FILE", path: tt.tokenFile}
+			giteaTokenFile = &secretFile{name: "GITEA_TOKEN_FILE", path: tt.giteaFile}
+			if got := currentGiteaToken(context.Background()); got != tt.want {
+				t.Errorf("currentGiteaToken() = %q, want %q", got, tt.want)
+			}
+			if got := currentToken(context.Background()); got != tt.wantToken {
+				t.Errorf("currentToken() = %q, want %q", got, tt.wantToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Starlark pipeline 生成器（与 Drone 的 .star 文件兼容：执行 main(ctx)）
+func isStarlarkFile(name string) bool {
+	return strings.HasSuffix(name, ".star")
+}
+
+// 允许 while、set 和顶层的 if/for，执行步数由 STARLARK_MAX_STEPS 限制
+var starlarkFileOptions = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+
+// 所有 .star 文件都可以使用的内置模块
+var starlarkPredeclared = starlark.StringDict{
+	"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	"json":   json.Module,
+}
+
+// 一次执行中 load() 的模块缓存；值为 nil 表示正在加载，用于检测循环
+type starlarkLoader struct {
+	load    func(p string) (GiteaFile, error)
+	modules map[string]*starlarkModule
+}
+
+type starlarkModule struct {
+	globals starlark.StringDict
+	err     error
+}
+
+// load("/lib/steps.star", "go_step")：以 / 开头相对配置仓库根目录，其他相对当前文件
+func (l *starlarkLoader) Load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	from := thread.CallFrame(0).Pos.Filename()
+	p := path.Join(path.Dir(from), module)
+	if strings.HasPrefix(module, "/") {
+		p = path.Clean(strings.TrimPrefix(module, "/"))
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return nil, fmt.Errorf("load path %q escapes the config repository", module)
+	}
+	if !isStarlarkFile(p) {
+		return nil, fmt.Errorf("load path %q is not a .star file", module)
+	}
+
+	if m, ok := l.modules[p]; ok {
+		if m == nil {
+			return nil, fmt.Errorf("load cycle: %s", p)
+		}
+		return m.globals, m.err
+	}
+	l.modules[p] = nil
+
+	file, err := l.load(p)
+	if err != nil {
+		err = fmt.Errorf("load %s: %w", p, err)
+		l.modules[p] = &starlarkModule{err: err}
+		return nil, err
+	}
+	globals, err := starlark.ExecFileOptions(starlarkFileOptions, thread, p, file.Content, starlarkPredeclared)
+	l.modules[p] = &starlarkModule{globals: globals, err: err}
+	return globals, err
+}
+
+// 执行一个 .star 文件的 main(ctx)，返回字典或字典列表。
+// 没有 main 函数的文件只作为 load() 的库，不生成 pipeline
+func evaluateStarlark(ctx context.Context, file GiteaFile, data TemplateData, location fileTemplateLocation, load func(p string) (GiteaFile, error)) ([]ConfigFile, error) {
+	input, err := generatorInput(data, location)
+	if err != nil {
+		return nil, err
+	}
+	// 兼容 Drone 的 ctx.build
+	input["build"] = input["pipeline"]
+	fields := make(starlark.StringDict)
+	for key, value := range input {
+		fields[key] = toStarlark(value)
+	}
+	starlarkCtx := starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+
+	loader := &starlarkLoader{load: load, modules: make(map[string]*starlarkModule)}
+	thread := &starlark.Thread{
+		Name: file.Path,
+		Load: loader.Load,
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.DebugContext(ctx, "starlark print", "file", file.Path, "message", msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(uint64(StarlarkMaxSteps))
+
+	// 超时或请求取消时中断执行
+	ctx, cancel := context.WithTimeout(ctx, StarlarkTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	globals, err := starlark.ExecFileOptions(starlarkFileOptions, thread, file.Path, file.Content, starlarkPredeclared)
+	if err != nil {
+		return nil, fmt.Errorf("starlark: %s", starlarkErrorMessage(err))
+	}
+	main, ok := globals["main"]
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := main.(starlark.Callable); !ok {
+		return nil, fmt.Errorf("starlark: %s: main must be a function", file.Path)
+	}
+	value, err := starlark.Call(thread, main, starlark.Tuple{starlarkCtx}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("starlark: %s", starlarkErrorMessage(err))
+	}
+
+	result, err := fromStarlark(value)
+	if err != nil {
+		return nil, fmt.Errorf("starlark: %s: %w", file.Path, err)
+	}
+	configs, err := generatedConfigs(file, result)
+	if err != nil {
+		return nil, fmt.Errorf("starlark: %w", err)
+	}
+	return configs, nil
+}
+
+// 执行错误带上调用栈（file:line:col）
+func starlarkErrorMessage(err error) string {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return singleLineError(fmt.Errorf("%s", evalErr.Backtrace()))
+	}
+	return singleLineError(err)
+}
+
+// 检查 .star 文件的语法和未定义的名称，不执行
+func checkStarlark(file GiteaFile) error {
+	_, _, err := starlark.SourceProgramOptions(starlarkFileOptions, file.Path, file.Content, starlarkPredeclared.Has)
+	return err
+}
+
+// JSON 风格的数据转换为 Starlark 值：对象转为 struct，便于写 ctx.repo.name
+func toStarlark(v interface{}) starlark.Value {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(v)
+	case float64:
+		if v == float64(int64(v)) {
+			return starlark.MakeInt64(int64(v))
+		}
+		return starlark.Float(v)
+	case string:
+		return starlark.String(v)
+	case []interface{}:
+		items := make([]starlark.Value, len(v))
+		for i, item := range v {
+			items[i] = toStarlark(item)
+		}
+		return starlark.NewList(items)
+	case map[string]interface{}:
+		fields := make(starlark.StringDict, len(v))
+		for key, value := range v {
+			fields[key] = toStarlark(value)
+		}
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+	default:
+		return starlark.String(fmt.Sprint(v))
+	}
+}
+
+// main 的返回值转换为可以序列化为 YAML 的数据
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List:
+		return fromStarlarkIterable(v)
+	case starlark.Tuple:
+		return fromStarlarkIterable(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			value, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(key)] = value
+		}
+		return m, nil
+	case *starlarkstruct.Struct:
+		names := v.AttrNames()
+		m := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			attr, _ := v.Attr(name)
+			value, err := fromStarlark(attr)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = value
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %s", v.Type())
+	}
+}
+
+func fromStarlarkIterable(v starlark.Iterable) ([]interface{}, error) {
+	items := []interface{}{}
+	iter := v.Iterate()
+	defer iter.Done()
+	var item starlark.Value
+	for iter.Next(&item) {
+		value, err := fromStarlark(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// 语法错误和名称解析错误转换为带行号的诊断信息
+func starlarkDiagnostics(file string, err error) []diagnostic {
+	var diags []diagnostic
+	switch err := err.(type) {
+	case syntax.Error:
+		diags = append(diags, diagnostic{File: file, Line: int(err.Pos.Line), Severity: severityError, Message: err.Msg})
+	case resolve.ErrorList:
+		for _, e := range err {
+			diags = append(diags, diagnostic{File: file, Line: int(e.Pos.Line), Severity: severityError, Message: e.Msg})
+		}
+	default:
+		diags = append(diags, diagnostic{File: file, Severity: severityError, Message: err.Error()})
+	}
+	return diags
+}
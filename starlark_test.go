@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateStarlark(t *testing.T) {
+	libs := map[string]string{
+		"lib/steps.star": "def go_step(name, cmd):\n    return {\"name\": name, \"image\": \"golang:1.24\", \"commands\": [cmd]}\n",
+		"lib/a.star":     "load(\"b.star\", \"b\")\na = 1\n",
+		"lib/b.star":     "load(\"a.star\", \"a\")\nb = 1\n",
+	}
+	load := func(p string) (GiteaFile, error) {
+		content, ok := libs[p]
+		if !ok {
+			return GiteaFile{}, fs.ErrNotExist
+		}
+		return GiteaFile{Name: p, Path: p, Content: content}, nil
+	}
+	data := TemplateData{
+		Repo:     RepoInfo{Owner: "team", Name: "app"},
+		Pipeline: PipelineInfo{Branch: "main", Event: "push"},
+	}
+	location := fileTemplateLocation{Path: "app/main"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "dict with load and ctx",
+			content: "load(\"/lib/steps.star\", \"go_step\")\n\ndef main(ctx):\n    return {\"steps\": [go_step(\"test\", \"go test ./...\")], \"when\": {\"branch\": ctx.build.branch, \"event\": ctx.pipeline.event}}\n",
+			want:    map[string]string{"build": "steps:\n    - commands:\n        - go test ./...\n      image: golang:1.24\n      name: test\nwhen:\n    branch: main\n    event: push\n"},
+		},
+		{
+			name:    "list produces one config per element",
+			content: "def main(ctx):\n    return [{\"steps\": [{\"name\": n, \"image\": ctx.repo.name}]} for n in (\"a\", \"b\")]\n",
+			want: map[string]string{
+				"build-1": "steps:\n    - image: app\n      name: a\n",
+				"build-2": "steps:\n    - image: app\n      name: b\n",
+			},
+		},
+		{
+			name:    "files without main are libraries",
+			content: "x = 1\n",
+			want:    map[string]string{},
+		},
+		{
+			name:    "runtime errors include file and line",
+			content: "def main(ctx):\n    return {\"steps\": ctx.repo.missing}\n",
+			wantErr: "app/main/build.star:2:",
+		},
+		{
+			name:    "step limit",
+			content: "def main(ctx):\n    n = 0\n    while True:\n        n += 1\n",
+			wantErr: "too many steps",
+		},
+		{
+			name:    "load cycle",
+			content: "load(\"/lib/a.star\", \"a\")\n",
+			wantErr: "load cycle",
+		},
+		{
+			name:    "loads cannot escape the config repository",
+			content: "load(\"../../../x.star\", \"x\")\n",
+			wantErr: "escapes the config repository",
+		},
+		{
+			name:    "result must be a dict",
+			content: "def main(ctx):\n    return \"steps\"\n",
+			wantErr: "must produce an object or a list of objects",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := GiteaFile{Name: "build.star", Path: "app/main/build.star", Content: tt.content}
+			configs, err := evaluateStarlark(context.Background(), file, data, location, load)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evaluateStarlark() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluateStarlark() error = %v", err)
+			}
+			if len(configs) != len(tt.want) {
+				t.Fatalf("got %d configs, want %d: %+v", len(configs), len(tt.want), configs)
+			}
+			for _, c := range configs {
+				if c.Data != tt.want[c.Name] {
+					t.Errorf("config %s =\n%s\nwant:\n%s", c.Name, c.Data, tt.want[c.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateStarlarkTimeout(t *testing.T) {
+	previousSteps, previousTimeout := StarlarkMaxSteps, StarlarkTimeout
+	StarlarkMaxSteps, StarlarkTimeout = 0, 50*time.Millisecond
+	t.Cleanup(func() { StarlarkMaxSteps, StarlarkTimeout = previousSteps, previousTimeout })
+
+	file := GiteaFile{Name: "build.star", Path: "build.star", Content: "def main(ctx):\n    while True:\n        pass\n"}
+	_, err := evaluateStarlark(context.Background(), file, TemplateData{}, fileTemplateLocation{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("evaluateStarlark() error = %v, want timeout", err)
+	}
+}
+
+func TestValidateTreeStarlark(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.star":  "def main(ctx):\n    return {\"steps\": []}\n",
+		"app/main/broken.star": "def main(ctx):\n    return {\"steps\": undefined_name}\n",
+	})
+
+	diags, err := validateTree(root, nil, defaultValidatePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].File != "app/main/broken.star" || diags[0].Line != 2 || !strings.Contains(diags[0].Message, "undefined_name") {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
+
+func TestResolveConfigStarlark(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"team/woodpeckerfiles/main/lib/steps.star":        "def step(name):\n    return {\"name\": name, \"image\": \"alpine\"}\n",
+		"team/woodpeckerfiles/main/app/main/build.star":   "load(\"/lib/steps.star\", \"step\")\n\ndef main(ctx):\n    return {\"steps\": [step(ctx.repo.name)]}\n",
+		"team/woodpeckerfiles/main/app/main/helpers.star": "def helper():\n    return 1\n",
+	})
+	useConfigDir(t, root, "{{ .Namespace }}/{{ .Repo }}/{{ .Branch }}/{{ .Path }}")
+
+	req := ConfigRequest{
+		Repo:     RepoInfo{Owner: "team", Name: "app", FullName: "team/app"},
+		Pipeline: PipelineInfo{Branch: "main"},
+	}
+	res, err := resolveConfig(context.Background(), req)
+	if err != nil {
+		t.Fatalf("resolveConfig() error = %v", err)
+	}
+	if len(res.Configs) != 1 || res.Configs[0].Name != "build" || !strings.Contains(res.Configs[0].Data, "name: app") || len(res.Errors) != 0 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
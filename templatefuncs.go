@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/hashicorp/go-version"
+)
+
+// 四个位置模板可用的函数；参数顺序让被处理的值放在最后，便于管道写法：
+// {{ .Pipeline.Ref | trimPrefix "refs/tags/" }}
+var templateFuncs = template.FuncMap{
+	// 字符串
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"trunc":      templateTrunc,
+
+	// 正则与通配
+	"regexMatch":   templateRegexMatch,
+	"regexFind":    templateRegexFind,
+	"regexReplace": templateRegexReplace,
+	"globMatch":    templateGlobMatch,
+
+	// 默认值
+	"default":  templateDefault,
+	"coalesce": templateCoalesce,
+
+	// 路径
+	"pathJoin":  path.Join,
+	"pathClean": path.Clean,
+	"pathBase":  path.Base,
+	"pathDir":   path.Dir,
+
+	// 语义化版本
+	"semver":      templateSemver,
+	"semverMatch": templateSemverMatch,
+
+	// 环境变量（只允许 TEMPLATE_ENV_ALLOWLIST 中的变量）
+	"env": templateEnv,
+
+	// 哈希
+	"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha1sum":   func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+}
+
+// 截取前 n 个字符（按 rune），n 为负数时截取后 -n 个字符
+func templateTrunc(n int, s string) string {
+	runes := []rune(s)
+	switch {
+	case n >= 0 && n < len(runes):
+		return string(runes[:n])
+	case n < 0 && -n < len(runes):
+		return string(runes[len(runes)+n:])
+	}
+	return s
+}
+
+// 编译过的正则按表达式缓存，模板每次请求都会重新执行
+var templateRegexps sync.Map
+
+func compileTemplateRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := templateRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	templateRegexps.Store(pattern, re)
+	return re, nil
+}
+
+func templateRegexMatch(pattern, s string) (bool, error) {
+	re, err := compileTemplateRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func templateRegexFind(pattern, s string) (string, error) {
+	re, err := compileTemplateRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.FindString(s), nil
+}
+
+// 替换所有匹配，repl 中可以使用 $1、${name} 引用分组
+func templateRegexReplace(pattern, repl, s string) (string, error) {
+	re, err := compileTemplateRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// shell 风格通配，* 不匹配 /，如 globMatch "release/*" .Pipeline.Branch
+func templateGlobMatch(pattern, s string) (bool, error) {
+	return path.Match(pattern, s)
+}
+
+// value 为空字符串或 nil 时返回 def
+func templateDefault(def, value any) any {
+	if value == nil {
+		return def
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return def
+	}
+	return value
+}
+
+// 返回第一个非空字符串
+func templateCoalesce(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// 解析后的语义化版本，允许 v 前缀和 refs/tags/ 前缀
+type templateVersion struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Metadata   string
+	Original   string
+}
+
+func (v templateVersion) String() string {
+	return v.Original
+}
+
+func templateSemver(s string) (templateVersion, error) {
+	s = strings.TrimPrefix(s, "refs/tags/")
+	v, err := version.NewSemver(s)
+	if err != nil {
+		return templateVersion{}, fmt.Errorf("semver %q: %w", s, err)
+	}
+	segments := v.Segments()
+	return templateVersion{
+		Major:      segments[0],
+		Minor:      segments[1],
+		Patch:      segments[2],
+		Prerelease: v.Prerelease(),
+		Metadata:   v.Metadata(),
+		Original:   s,
+	}, nil
+}
+
+// 版本是否满足约束，如 semverMatch ">= 2.0, < 3.0" .Pipeline.Ref
+func templateSemverMatch(constraint, s string) (bool, error) {
+	c, err := version.NewConstraint(constraint)
+	if err != nil {
+		return false, fmt.Errorf("semver constraint %q: %w", constraint, err)
+	}
+	v, err := version.NewSemver(strings.TrimPrefix(s, "refs/tags/"))
+	if err != nil {
+		return false, fmt.Errorf("semver %q: %w", s, err)
+	}
+	return c.Check(v), nil
+}
+
+// 读取环境变量，名称必须匹配 TEMPLATE_ENV_ALLOWLIST 中的某一项（逗号分隔，支持 * 通配）
+func templateEnv(name string) (string, error) {
+	for _, pattern := range strings.Split(TemplateEnvAllowlist, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if ok, _ := path.Match(pattern, name); ok && pattern != "" {
+			return os.Getenv(name), nil
+		}
+	}
+	return "", fmt.Errorf("env %q is not in TEMPLATE_ENV_ALLOWLIST", name)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Setenv("DEPLOY_REGION", "eu-west")
+	t.Setenv("TOKEN_FOR_TEST", "do-not-leak")
+	previous := TemplateEnvAllowlist
+	TemplateEnvAllowlist = "CI_*, DEPLOY_REGION"
+	t.Cleanup(func() { TemplateEnvAllowlist = previous })
+
+	data := TemplateData{
+		Repo: RepoInfo{Owner: "Platform-Team", Name: "api"},
+		Pipeline: PipelineInfo{
+			Branch: "release/2.4",
+			Ref:    "refs/tags/v2.4.1-rc.1+build.7",
+			Commit: "9f2d4c1b7e0a5d3c8b6f1e2a4d7c9b0e3f5a8c21",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{"lower", `{{ .Repo.Owner | lower }}`, "platform-team", ""},
+		{"upper", `{{ upper .Repo.Name }}`, "API", ""},
+		{"trim", `{{ trim "  main \n" }}`, "main", ""},
+		{"trimPrefix", `{{ .Pipeline.Ref | trimPrefix "refs/tags/" }}`, "v2.4.1-rc.1+build.7", ""},
+		{"trimSuffix", `{{ "build.yml" | trimSuffix ".yml" }}`, "build", ""},
+		{"replace", `{{ .Pipeline.Branch | replace "/" "-" }}`, "release-2.4", ""},
+		{"contains", `{{ contains "release" .Pipeline.Branch }}`, "true", ""},
+		{"hasPrefix", `{{ hasPrefix "refs/tags/" .Pipeline.Ref }}`, "true", ""},
+		{"hasSuffix", `{{ hasSuffix ".4" .Pipeline.Branch }}`, "true", ""},
+		{"split", `{{ index (split "/" .Pipeline.Branch) 0 }}`, "release", ""},
+		{"join", `{{ split "-" .Repo.Owner | join "_" }}`, "Platform_Team", ""},
+		{"trunc", `{{ trunc 7 .Pipeline.Commit }}`, "9f2d4c1", ""},
+		{"trunc from end", `{{ trunc -4 .Pipeline.Commit }}`, "8c21", ""},
+		{"trunc longer than value", `{{ trunc 10 .Repo.Name }}`, "api", ""},
+		{"regexMatch", `{{ regexMatch "^release/[0-9.]+$" .Pipeline.Branch }}`, "true", ""},
+		{"regexFind", `{{ regexFind "[0-9]+\\.[0-9]+" .Pipeline.Branch }}`, "2.4", ""},
+		{"regexReplace", `{{ .Pipeline.Branch | regexReplace "^release/.*$" "release" }}`, "release", ""},
+		{"regexReplace groups", `{{ .Pipeline.Branch | regexReplace "^(\\w+)/(.*)$" "${2}-${1}" }}`, "2.4-release", ""},
+		{"regexReplace invalid", `{{ regexReplace "(" "" .Repo.Name }}`, "", "missing closing )"},
+		{"globMatch", `{{ if globMatch "release/*" .Pipeline.Branch }}release{{ else }}{{ .Pipeline.Branch }}{{ end }}`, "release", ""},
+		{"globMatch no match across slash", `{{ globMatch "*" .Pipeline.Branch }}`, "false", ""},
+		{"default empty", `{{ .Pipeline.DeployTo | default "staging" }}`, "staging", ""},
+		{"default set", `{{ .Repo.Name | default "fallback" }}`, "api", ""},
+		{"coalesce", `{{ coalesce .Pipeline.DeployTo .Repo.Branch "main" }}`, "main", ""},
+		{"pathJoin", `{{ pathJoin .Repo.Name "ci" .Pipeline.Branch }}`, "api/ci/release/2.4", ""},
+		{"pathClean", `{{ pathClean "api//ci/./main/" }}`, "api/ci/main", ""},
+		{"pathBase", `{{ pathBase .Pipeline.Branch }}`, "2.4", ""},
+		{"pathDir", `{{ pathDir .Pipeline.Branch }}`, "release", ""},
+		{"semver", `{{ with semver .Pipeline.Ref }}{{ .Major }}.{{ .Minor }}.{{ .Patch }} {{ .Prerelease }} {{ .Metadata }}{{ end }}`, "2.4.1 rc.1 build.7", ""},
+		{"semver string", `{{ semver "v1.2" }}`, "v1.2", ""},
+		{"semver invalid", `{{ semver .Pipeline.Branch }}`, "", "semver"},
+		{"semverMatch", `{{ semverMatch ">= 2.0, < 3.0" "v2.4.1" }}`, "true", ""},
+		{"semverMatch prerelease", `{{ semverMatch ">= 2.0" .Pipeline.Ref }}`, "false", ""},
+		{"env allowed", `{{ env "DEPLOY_REGION" }}`, "eu-west", ""},
+		{"env allowed by glob", `{{ env "CI_UNSET_VARIABLE" | default "none" }}`, "none", ""},
+		{"env not allowed", `{{ env "TOKEN_FOR_TEST" }}`, "", "not in TEMPLATE_ENV_ALLOWLIST"},
+		{"sha256sum", `{{ sha256sum "abc" | trunc 12 }}`, "ba7816bf8f01", ""},
+		{"sha1sum", `{{ sha1sum "abc" }}`, "a9993e364706816aba3e25717850c26c9cd0d89d", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.template, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renderTemplate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// 已编译的模板，按模板字符串缓存，每个模板只解析一次
+var compiledTemplates sync.Map
+
+// 解析模板：map 缺少 key 时报错，并静态检查引用的字段是否存在
+func parseTemplate(tmplStr string) (*template.Template, error) {
+	if tmpl, ok := compiledTemplates.Load(tmplStr); ok {
+		return tmpl.(*template.Template), nil
+	}
+
+	tmpl, err := template.New("config").Funcs(templateFuncs).Option("missingkey=error").Parse(tmplStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse template error: %w", err)
+	}
+	if err := checkTemplateFields(tmpl.Tree.Root, reflect.TypeOf(TemplateData{}), true); err != nil {
+		return nil, fmt.Errorf("parse template error: %w", err)
+	}
+	compiledTemplates.Store(tmplStr, tmpl)
+	return tmpl, nil
+}
+
+// 渲染模板
+func renderTemplate(tmplStr string, data TemplateData) (string, error) {
+	tmpl, err := parseTemplate(tmplStr)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute template error: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// 位置模板及对应的环境变量名
+func locationTemplates() []struct{ env, tmpl string } {
+	return []struct{ env, tmpl string }{
+		{"WOODPECKER_CONFIG_NAMESPACE_TEMP", NamespaceTemplate},
+		{"WOODPECKER_CONFIG_REPONAME_TEMP", RepoNameTemplate},
+		{"WOODPECKER_CONFIG_BRANCH_TEMP", BranchTemplate},
+		{"WOODPECKER_CONFIG_YAMLPATH_TEMP", PathTemplate},
+	}
+}
+
+// 启动时编译四个位置模板，模板有误时直接失败，而不是等到流水线运行
+func setupTemplates() error {
+	for _, t := range locationTemplates() {
+		if _, err := parseTemplate(t.tmpl); err != nil {
+			return fmt.Errorf("invalid %s: %w", t.env, err)
+		}
+	}
+	for i, layer := range overlayLayers() {
+		if _, err := parseTemplate(layer); err != nil {
+			return fmt.Errorf("invalid OVERLAY_LAYERS layer %d: %w", i+1, err)
+		}
+	}
+	if _, err := parseTemplateVars(TemplateVars); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 渲染 namespace/repo/branch/path 四个模板
+func renderLocation(data TemplateData) (namespace, repoName, branch, path string, err error) {
+	if namespace, err = renderTemplate(NamespaceTemplate, data); err != nil {
+		return "", "", "", "", fmt.Errorf("render namespace template: %w", err)
+	}
+	if repoName, err = renderTemplate(RepoNameTemplate, data); err != nil {
+		return "", "", "", "", fmt.Errorf("render reponame template: %w", err)
+	}
+	if branch, err = renderTemplate(BranchTemplate, data); err != nil {
+		return "", "", "", "", fmt.Errorf("render branch template: %w", err)
+	}
+	if path, err = renderTemplate(PathTemplate, data); err != nil {
+		return "", "", "", "", fmt.Errorf("render path template: %w", err)
+	}
+
+	// GitLab 的 namespace 可以为空（reponame 为完整路径或数字 ID）
+	checks := []struct {
+		name, value string
+		allowEmpty  bool
+	}{
+		{"namespace", namespace, strings.EqualFold(ServerType, "gitlab")},
+		{"reponame", repoName, false},
+		{"branch", branch, false},
+		{"path", path, false},
+	}
+	for _, c := range checks {
+		if err := checkRenderedValue(c.value, c.allowEmpty); err != nil {
+			return "", "", "", "", fmt.Errorf("render %s template: %w", c.name, err)
+		}
+	}
+	return namespace, repoName, branch, path, nil
+}
+
+// 渲染结果不能为空，也不能包含 .. 路径组件
+func checkRenderedValue(value string, allowEmpty bool) error {
+	if strings.TrimSpace(value) == "" {
+		if allowEmpty {
+			return nil
+		}
+		return fmt.Errorf("rendered value is empty")
+	}
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("rendered value %q contains path traversal", value)
+		}
+	}
+	return nil
+}
+
+// 检查模板中以根数据开头的字段（.Repo.Name、$.Pipeline.Ref）在 root 类型中存在；
+// range/with 内部的 . 指向其他值，不做检查
+func checkTemplateFields(node parse.Node, root reflect.Type, rootDot bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateFields(child, root, rootDot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplateFields(n.Pipe, root, rootDot)
+	case *parse.TemplateNode:
+		return checkTemplateFields(n.Pipe, root, rootDot)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if err := checkTemplateFields(arg, root, rootDot); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.ChainNode:
+		return checkTemplateFields(n.Node, root, rootDot)
+	case *parse.IfNode:
+		return checkBranchFields(&n.BranchNode, root, rootDot, rootDot)
+	case *parse.RangeNode:
+		return checkBranchFields(&n.BranchNode, root, rootDot, false)
+	case *parse.WithNode:
+		return checkBranchFields(&n.BranchNode, root, rootDot, false)
+	case *parse.FieldNode:
+		if rootDot {
+			return checkFieldChain(root, n.Ident)
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return checkFieldChain(root, n.Ident[1:])
+		}
+	}
+	return nil
+}
+
+func checkBranchFields(n *parse.BranchNode, root reflect.Type, rootDot, listRootDot bool) error {
+	if err := checkTemplateFields(n.Pipe, root, rootDot); err != nil {
+		return err
+	}
+	if err := checkTemplateFields(n.List, root, listRootDot); err != nil {
+		return err
+	}
+	return checkTemplateFields(n.ElseList, root, rootDot)
+}
+
+// 从 root 类型逐级查找字段，遇到 map 或方法后不再检查
+func checkFieldChain(root reflect.Type, idents []string) error {
+	typ := root
+	for i, name := range idents {
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if _, ok := typ.MethodByName(name); ok {
+			return nil
+		}
+		switch typ.Kind() {
+		case reflect.Map:
+			return nil
+		case reflect.Struct:
+			field, ok := typ.FieldByName(name)
+			if !ok || !field.IsExported() {
+				return fmt.Errorf("unknown field .%s", strings.Join(idents[:i+1], "."))
+			}
+			typ = field.Type
+		default:
+			return fmt.Errorf("can't evaluate field .%s in type %s", strings.Join(idents[:i+1], "."), typ)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplateFieldCheck(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{"{{ .Repo.Name }}/{{ .Pipeline.Branch }}", ""},
+		{"{{ .Repo.Nmae }}", "unknown field .Repo.Nmae"},
+		{"{{ .Pipeline.Branch.Name }}", "can't evaluate field .Pipeline.Branch.Name"},
+		{"{{ .Pipeline.Variables.CHANNEL }}", ""},
+		{"{{ .Repo.Trusted.Network }}", ""},
+		{"{{ if .Pipeline.DeployTo }}{{ .Pipeline.DeployTo }}{{ else }}{{ .Pipeline.Brnch }}{{ end }}", "unknown field .Pipeline.Brnch"},
+		{"{{ with semver .Pipeline.Ref }}{{ .Major }}{{ end }}", ""},
+		{"{{ with .Repo }}{{ .Name }}{{ $.Repo.Ownr }}{{ end }}", "unknown field .Repo.Ownr"},
+		{"{{ range .Pipeline.ChangedFiles }}{{ . }}{{ end }}", ""},
+		{"{{ (semver .Pipeline.Ref).Major }}", ""},
+		{"{{ .Repo.Name | lower | trimPrefix .Repo.Ownerr }}", "unknown field .Repo.Ownerr"},
+		{"{{ .Repo.Name", "unclosed action"},
+		{"{{ unknownFunc .Repo.Name }}", `function "unknownFunc" not defined`},
+	}
+	for _, tt := range tests {
+		_, err := parseTemplate(tt.template)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("parseTemplate(%q) error = %v", tt.template, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("parseTemplate(%q) error = %v, want %q", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseTemplateCached(t *testing.T) {
+	first, err := parseTemplate("{{ .Repo.Owner }}/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := parseTemplate("{{ .Repo.Owner }}/cached")
+	if first != second {
+		t.Error("template was parsed twice")
+	}
+}
+
+func TestRenderTemplateMissingKey(t *testing.T) {
+	data := TemplateData{Pipeline: PipelineInfo{Variables: map[string]string{"CHANNEL": "stable"}}}
+	if got, err := renderTemplate("{{ .Pipeline.Variables.CHANNEL }}", data); err != nil || got != "stable" {
+		t.Errorf("renderTemplate() = %q, %v", got, err)
+	}
+	if _, err := renderTemplate("{{ .Pipeline.Variables.MISSING }}", data); err == nil {
+		t.Error("expected error for missing map key")
+	}
+	// index 返回零值，可以配合 default 使用
+	if got, err := renderTemplate(`{{ index .Pipeline.Variables "MISSING" | default "beta" }}`, data); err != nil || got != "beta" {
+		t.Errorf("renderTemplate() with index = %q, %v", got, err)
+	}
+}
+
+func TestSetupTemplates(t *testing.T) {
+	previous := PathTemplate
+	t.Cleanup(func() { PathTemplate = previous })
+
+	PathTemplate = "{{ .Repo.Name }}/{{ .Pipeline.Branch }}"
+	if err := setupTemplates(); err != nil {
+		t.Fatalf("setupTemplates() error = %v", err)
+	}
+	PathTemplate = "{{ .Repo.Nmae }}"
+	if err := setupTemplates(); err == nil || !strings.Contains(err.Error(), "WOODPECKER_CONFIG_YAMLPATH_TEMP") {
+		t.Errorf("setupTemplates() error = %v, want invalid WOODPECKER_CONFIG_YAMLPATH_TEMP", err)
+	}
+}
+
+func TestRenderLocationRejectsInvalidValues(t *testing.T) {
+	previous := []string{ServerType, NamespaceTemplate, RepoNameTemplate, BranchTemplate, PathTemplate}
+	t.Cleanup(func() {
+		ServerType, NamespaceTemplate, RepoNameTemplate, BranchTemplate, PathTemplate = previous[0], previous[1], previous[2], previous[3], previous[4]
+	})
+	ServerType = "gitea"
+	NamespaceTemplate, RepoNameTemplate, BranchTemplate = "{{ .Repo.Owner }}", "woodpeckerfiles", "{{ .Pipeline.Branch }}"
+
+	tests := []struct {
+		name       string
+		serverType string
+		namespace  string
+		path       string
+		data       TemplateData
+		wantErr    string
+	}{
+		{"valid", "gitea", "{{ .Repo.Owner }}", "{{ .Repo.Name }}", TemplateData{Repo: RepoInfo{Owner: "team", Name: "app"}, Pipeline: PipelineInfo{Branch: "main"}}, ""},
+		{"empty branch", "gitea", "{{ .Repo.Owner }}", "{{ .Repo.Name }}", TemplateData{Repo: RepoInfo{Owner: "team", Name: "app"}}, "render branch template: rendered value is empty"},
+		{"traversal in path", "gitea", "{{ .Repo.Owner }}", "{{ .Repo.Name }}", TemplateData{Repo: RepoInfo{Owner: "team", Name: "../secrets"}, Pipeline: PipelineInfo{Branch: "main"}}, "render path template: rendered value \"../secrets\" contains path traversal"},
+		{"traversal in branch", "gitea", "{{ .Repo.Owner }}", "app", TemplateData{Repo: RepoInfo{Owner: "team"}, Pipeline: PipelineInfo{Branch: "feature/../../main"}}, "render branch template"},
+		{"dots inside a name are fine", "gitea", "{{ .Repo.Owner }}", "app..v2", TemplateData{Repo: RepoInfo{Owner: "team"}, Pipeline: PipelineInfo{Branch: "main"}}, ""},
+		{"empty namespace", "gitea", "", "app", TemplateData{Pipeline: PipelineInfo{Branch: "main"}}, "render namespace template: rendered value is empty"},
+		{"empty namespace on gitlab", "gitlab", "", "app", TemplateData{Pipeline: PipelineInfo{Branch: "main"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ServerType, NamespaceTemplate, PathTemplate = tt.serverType, tt.namespace, tt.path
+			_, _, _, _, err := renderLocation(tt.data)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("renderLocation() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("renderLocation() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
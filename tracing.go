@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "woodpecker-config-provider"
+
+// 初始化 OpenTelemetry 追踪。未配置 OTLP endpoint 时不导出任何数据，
+// 但仍然解析传入的 W3C trace context，保证日志中的 trace_id 与上游一致。
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if OTLPEndpoint == "" && OTLPTracesEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// endpoint、headers、TLS 等由 exporter 从标准 OTEL_EXPORTER_OTLP_* 环境变量读取
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不指定 schema URL，SDK 升级后 resource.Default() 的 schema 变化也不会导致合并冲突
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(getEnv("OTEL_SERVICE_NAME", tracerName)),
+		semconv.ServiceVersion(serviceVersion),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	slog.Info("tracing enabled", "endpoint", OTLPEndpoint+OTLPTracesEndpoint)
+	return provider.Shutdown, nil
+}
+
+// 开始一个 span
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// 结束 span，出错时记录错误状态
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, redactString(err.Error()))
+	}
+	span.End()
+}
+
+// 从请求头中提取上游的 trace context
+func extractTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// 解析后的模板值作为 span 属性
+func resolvedAttributes(namespace, repo, branch, path string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("config.namespace", namespace),
+		attribute.String("config.repo", repo),
+		attribute.String("config.branch", branch),
+		attribute.String("config.path", path),
+	}
+}
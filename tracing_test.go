@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 使用内存 recorder 替换全局 TracerProvider
+func setupTestTracer(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func TestSetupTracingDisabledByDefault(t *testing.T) {
+	previous := OTLPEndpoint
+	OTLPEndpoint = ""
+	defer func() { OTLPEndpoint = previous }()
+
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestSetupTracingEnabled(t *testing.T) {
+	previous := OTLPEndpoint
+	previousProvider := otel.GetTracerProvider()
+	OTLPEndpoint = "http://127.0.0.1:4318"
+	defer func() {
+		OTLPEndpoint = previous
+		otel.SetTracerProvider(previousProvider)
+	}()
+
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Errorf("tracer provider = %T, want SDK provider", otel.GetTracerProvider())
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestConfigRequestSpans(t *testing.T) {
+	recorder := setupTestTracer(t)
+
+	previousType := ServerType
+	ServerType = "unsupported"
+	defer func() { ServerType = previousType }()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	body := `{"repo":{"name":"app","owner":"team","full_name":"team/app"},"pipeline":{"branch":"main"}}`
+	req := httptest.NewRequest(http.MethodPost, "/ciconfig", strings.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+
+	handleConfigRequest(rec, req)
+
+	spans := recorder.Ended()
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		names[s.Name()] = s
+	}
+
+	for _, want := range []string{"POST /ciconfig", "render templates"} {
+		span, ok := names[want]
+		if !ok {
+			t.Errorf("missing span %q, got %d spans", want, len(spans))
+			continue
+		}
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("span %q trace id = %s, want %s (incoming context not honoured)", want, got, traceID)
+		}
+	}
+
+	render := names["render templates"]
+	if render == nil {
+		return
+	}
+	attrs := make(map[string]string)
+	for _, kv := range render.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsString()
+	}
+	if attrs["config.path"] != "app/main" || attrs["config.namespace"] != "team" {
+		t.Errorf("render span attributes = %v", attrs)
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v3"
+)
+
+// 校验诊断信息，输出为 file:line: severity: message
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (d diagnostic) String() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Message)
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// Woodpecker workflow 允许的顶层字段
+var workflowKeys = map[string]bool{
+	"when": true, "workspace": true, "clone": true, "skip_clone": true,
+	"steps": true, "services": true, "labels": true, "depends_on": true,
+	"runs_on": true, "matrix": true, "variables": true,
+}
+
+// 策略检查选项
+type validatePolicy struct {
+	DenyPrivileged    bool
+	DenyLatestTag     bool
+	AllowedRegistries []string
+	WarnUnknownKeys   bool
+}
+
+var defaultValidatePolicy = validatePolicy{
+	DenyPrivileged:  true,
+	WarnUnknownKeys: true,
+}
+
+// yaml.v3 的错误信息形如 "yaml: line 3: ..."
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// 校验单个 pipeline 文件
+func validateConfigFile(name string, data []byte, policy validatePolicy) []diagnostic {
+	var diags []diagnostic
+	report := func(line int, severity, format string, args ...interface{}) {
+		diags = append(diags, diagnostic{File: name, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []diagnostic{yamlDiagnostic(name, err)}
+	}
+	if len(doc.Content) == 0 {
+		report(0, severityError, "empty pipeline file")
+		return diags
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		report(root.Line, severityError, "pipeline must be a mapping, got %s", nodeKind(root))
+		return diags
+	}
+
+	var steps *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch {
+		case key.Value == "steps":
+			steps = value
+		case key.Value == "services":
+			validateSteps(value, "service", policy, report)
+		case !workflowKeys[key.Value] && !strings.HasPrefix(key.Value, "x-") && policy.WarnUnknownKeys:
+			report(key.Line, severityWarning, "unknown top-level key %q", key.Value)
+		}
+	}
+
+	if steps == nil {
+		report(root.Line, severityError, "missing required key \"steps\"")
+		return diags
+	}
+	validateSteps(steps, "step", policy, report)
+	return diags
+}
+
+// YAML 解析错误，行号从错误信息中提取
+func yamlDiagnostic(name string, err error) diagnostic {
+	line := 0
+	if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	return diagnostic{File: name, Line: line, Severity: severityError, Message: "invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")}
+}
+
+// 分层覆盖中的片段（覆盖层的文件或带合并标记的文件）只检查能否参与合并，
+// 完整的结构检查在合并后进行
+func validateFragment(name string, data []byte) []diagnostic {
+	docs, err := decodeYAMLDocuments(string(data))
+	if err != nil {
+		return []diagnostic{yamlDiagnostic(name, err)}
+	}
+	if len(docs) != 1 || len(docs[0].Content) == 0 {
+		return []diagnostic{{File: name, Severity: severityError, Message: "overlay files must contain exactly one YAML document"}}
+	}
+	if root := docs[0].Content[0]; root.Kind != yaml.MappingNode {
+		return []diagnostic{{File: name, Line: root.Line, Severity: severityError, Message: fmt.Sprintf("pipeline must be a mapping, got %s", nodeKind(root))}}
+	}
+	return nil
+}
+
+// 校验 steps/services，支持列表和 map 两种写法
+func validateSteps(node *yaml.Node, kind string, policy validatePolicy, report func(int, string, string, ...interface{})) {
+	type namedStep struct {
+		name string
+		line int
+		node *yaml.Node
+	}
+
+	var items []namedStep
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			items = append(items, namedStep{name: mappingValue(item, "name"), line: item.Line, node: item})
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			items = append(items, namedStep{name: node.Content[i].Value, line: node.Content[i].Line, node: node.Content[i+1]})
+		}
+	default:
+		report(node.Line, severityError, "%ss must be a list or mapping, got %s", kind, nodeKind(node))
+		return
+	}
+
+	if len(items) == 0 {
+		report(node.Line, severityError, "no %ss defined", kind)
+	}
+
+	seen := make(map[string]int)
+	for i, item := range items {
+		label := item.name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		} else if first, dup := seen[label]; dup {
+			report(item.line, severityError, "duplicate %s name %q (first defined on line %d)", kind, label, first)
+		} else {
+			seen[label] = item.line
+		}
+
+		if item.node.Kind != yaml.MappingNode {
+			report(item.line, severityError, "%s %s must be a mapping, got %s", kind, label, nodeKind(item.node))
+			continue
+		}
+
+		image := mappingNode(item.node, "image")
+		if image == nil || image.Value == "" {
+			report(item.line, severityError, "%s %s: missing \"image\"", kind, label)
+		} else {
+			validateImage(image, kind, label, policy, report)
+		}
+
+		if commands := mappingNode(item.node, "commands"); commands != nil &&
+			commands.Kind != yaml.SequenceNode && commands.Kind != yaml.ScalarNode {
+			report(commands.Line, severityError, "%s %s: \"commands\" must be a list or string", kind, label)
+		} else if commands != nil && commands.Kind == yaml.SequenceNode {
+			for _, c := range commands.Content {
+				if c.Kind != yaml.ScalarNode {
+					report(c.Line, severityError, "%s %s: command must be a string, got %s (quote commands containing \": \")", kind, label, nodeKind(c))
+				}
+			}
+		}
+
+		if privileged := mappingNode(item.node, "privileged"); privileged != nil && privileged.Value == "true" && policy.DenyPrivileged {
+			report(privileged.Line, severityError, "%s %s: privileged mode is not allowed", kind, label)
+		}
+	}
+}
+
+// 镜像相关的策略检查
+func validateImage(image *yaml.Node, kind, label string, policy validatePolicy, report func(int, string, string, ...interface{})) {
+	ref := image.Value
+	if policy.DenyLatestTag {
+		name := ref
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if !strings.Contains(name, ":") && !strings.Contains(ref, "@") || strings.HasSuffix(ref, ":latest") {
+			report(image.Line, severityError, "%s %s: image %q must use a pinned tag", kind, label, ref)
+		}
+	}
+
+	if len(policy.AllowedRegistries) > 0 {
+		allowed := false
+		for _, registry := range policy.AllowedRegistries {
+			if strings.HasPrefix(ref, strings.TrimSuffix(registry, "/")+"/") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			report(image.Line, severityError, "%s %s: image %q is not from an allowed registry (%s)",
+				kind, label, ref, strings.Join(policy.AllowedRegistries, ", "))
+		}
+	}
+}
+
+func mappingNode(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yaml.Node, key string) string {
+	if n := mappingNode(node, key); n != nil {
+		return n.Value
+	}
+	return ""
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.ScalarNode:
+		return "scalar"
+	case yaml.AliasNode:
+		return "alias"
+	}
+	return "unknown"
+}
+
+// 需要模拟的仓库/分支组合
+type validateTarget struct {
+	Repo   string
+	Branch string
+}
+
+// 校验本地配置仓库：所有 YAML 文件 + 每个目标仓库解析出的路径
+func validateTree(root string, targets []validateTarget, policy validatePolicy) ([]diagnostic, error) {
+	var diags []diagnostic
+	var files []GiteaFile
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isConfigFile(d.Name()) {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		files = append(files, GiteaFile{Name: d.Name(), Path: filepath.ToSlash(rel), Content: string(data)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 分层覆盖时，各目标覆盖层（第二层起）中的文件是片段，不要求是完整的 pipeline
+	fragments := make(map[string]bool)
+	for _, target := range targets {
+		_, paths, diag := targetPaths(target)
+		if diag != nil || len(overlayLayers()) == 0 {
+			continue
+		}
+		for _, file := range files {
+			for _, p := range paths[1:] {
+				if path.Dir(file.Path) == path.Clean(strings.Trim(p, "/")) {
+					fragments[file.Path] = true
+				}
+			}
+		}
+	}
+
+	// 先展开所有 include，被其他文件包含的片段不单独作为 pipeline 校验
+	includes := newIncludeResolver(localIncludeLoader(root), func(f GiteaFile) (string, error) {
+		return f.Content, nil
+	})
+	expanded := make([]*string, len(files))
+	for i, file := range files {
+		if isJsonnetFile(file.Name) || isStarlarkFile(file.Name) || isCUEFile(file.Name) || isFileTemplate(file.Name, file.Content) {
+			continue
+		}
+		content, err := includes.expand(file)
+		if err != nil {
+			diags = append(diags, diagnostic{File: file.Path, Severity: severityError, Message: err.Error()})
+			continue
+		}
+		expanded[i] = &content
+	}
+	for i, file := range files {
+		// Jsonnet 依赖请求数据才能执行，只检查语法
+		if isJsonnetFile(file.Name) {
+			if _, err := jsonnet.SnippetToAST(file.Path, file.Content); err != nil {
+				// 错误格式为 file:line:col message
+				location, message, _ := strings.Cut(strings.TrimPrefix(singleLineError(err), file.Path+":"), " ")
+				line, _ := strconv.Atoi(strings.SplitN(location, ":", 2)[0])
+				diags = append(diags, diagnostic{File: file.Path, Line: line, Severity: severityError, Message: message})
+			}
+			continue
+		}
+		// Starlark 同样只检查语法和未定义的名称
+		if isStarlarkFile(file.Name) {
+			if err := checkStarlark(file); err != nil {
+				diags = append(diags, starlarkDiagnostics(file.Path, err)...)
+			}
+			continue
+		}
+		// CUE 不注入请求，只检查语法和合一冲突
+		if isCUEFile(file.Name) {
+			if _, err := compileCUE(file); err != nil {
+				diags = append(diags, cueDiagnostics(file.Path, err)...)
+			}
+			continue
+		}
+		// 模板文件渲染前不是合法 YAML，只检查模板语法
+		if isFileTemplate(file.Name, file.Content) {
+			if _, err := parseFileTemplate(file.Name, file.Content); err != nil {
+				diags = append(diags, diagnostic{File: file.Path, Severity: severityError, Message: err.Error()})
+			}
+			continue
+		}
+		if _, ok := includes.files[file.Path]; ok || expanded[i] == nil {
+			continue
+		}
+		if len(overlayLayers()) > 0 && (fragments[file.Path] || hasMergeMarkers(*expanded[i])) {
+			diags = append(diags, validateFragment(file.Path, []byte(*expanded[i]))...)
+			continue
+		}
+		// 矩阵文件还要检查 matrix 能否展开；body 中的 ${维度} 不影响结构校验
+		if isMatrixFile(file.Name) {
+			if _, err := expandMatrix(file, *expanded[i]); err != nil {
+				diags = append(diags, matrixDiagnostic(file.Path, err))
+			}
+		}
+		diags = append(diags, validateConfigFile(file.Path, []byte(*expanded[i]), policy)...)
+	}
+
+	// 展开后的内容，供合并各层时使用
+	contents := make(map[string]string)
+	for i, file := range files {
+		if expanded[i] != nil {
+			contents[file.Path] = *expanded[i]
+		}
+	}
+	for _, target := range targets {
+		diags = append(diags, validateTargetPath(root, target, contents, fragments, policy)...)
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].File != diags[j].File {
+			return diags[i].File < diags[j].File
+		}
+		return diags[i].Line < diags[j].Line
+	})
+	return diags, nil
+}
+
+// 模拟请求的目标位置：未配置 OVERLAY_LAYERS 时只有路径模板一层
+func targetPaths(target validateTarget) (string, []string, *diagnostic) {
+	label := target.Repo + "@" + target.Branch
+	owner, name, ok := strings.Cut(target.Repo, "/")
+	if !ok {
+		return label, nil, &diagnostic{File: label, Severity: severityError, Message: "repo must be in the form owner/name"}
+	}
+
+	data := TemplateData{
+		Repo: RepoInfo{Owner: owner, Namespace: owner, Name: name, FullName: target.Repo},
+		Pipeline: PipelineInfo{
+			Branch: target.Branch,
+			Event:  "push",
+			Ref:    "refs/heads/" + target.Branch,
+		},
+	}
+	if len(overlayLayers()) > 0 {
+		paths, err := renderLayers(data)
+		if err != nil {
+			return label, nil, &diagnostic{File: label, Severity: severityError, Message: err.Error()}
+		}
+		return label, paths, nil
+	}
+	path, err := renderTemplate(PathTemplate, data)
+	if err != nil {
+		return label, nil, &diagnostic{File: label, Severity: severityError, Message: fmt.Sprintf("render path template: %v", err)}
+	}
+	return label, []string{path}, nil
+}
+
+// 用路径模板模拟一次请求，检查目标目录（分层覆盖时任意一层）中存在 pipeline 文件，
+// 并校验合并了覆盖层的文件
+func validateTargetPath(root string, target validateTarget, contents map[string]string, fragments map[string]bool, policy validatePolicy) []diagnostic {
+	label, paths, diag := targetPaths(target)
+	if diag != nil {
+		return []diagnostic{*diag}
+	}
+
+	// 同名文件（去掉后缀后）在各层中的路径，按第一次出现的顺序
+	var names []string
+	layered := make(map[string][]string)
+	exists := false
+	for _, p := range paths {
+		entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(p)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return []diagnostic{{File: label, Severity: severityError, Message: err.Error()}}
+		}
+		exists = true
+		for _, entry := range entries {
+			if entry.IsDir() || !isConfigFile(entry.Name()) || isJsonnetLibrary(entry.Name()) {
+				continue
+			}
+			name := pipelineName(entry.Name())
+			if layered[name] == nil {
+				names = append(names, name)
+			}
+			layered[name] = append(layered[name], strings.TrimPrefix(strings.Trim(p, "/")+"/"+entry.Name(), "/"))
+		}
+	}
+
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		quoted[i] = strconv.Quote(p)
+	}
+	what := "config path " + strings.Join(quoted, ", ")
+	if len(paths) > 1 {
+		what = "overlay layers " + strings.Join(quoted, ", ")
+	}
+	if !exists {
+		verb := "does not exist"
+		if len(paths) > 1 {
+			verb = "do not exist"
+		}
+		return []diagnostic{{File: label, Severity: severityError, Message: fmt.Sprintf("%s %s", what, verb)}}
+	}
+	if len(names) == 0 {
+		verb := "contains"
+		if len(paths) > 1 {
+			verb = "contain"
+		}
+		return []diagnostic{{File: label, Severity: severityError, Message: fmt.Sprintf("%s %s no pipeline files", what, verb)}}
+	}
+	if len(paths) == 1 {
+		return nil
+	}
+
+	// 只出现在非覆盖层的文件已经单独校验过；模板、生成器和矩阵文件需要请求数据才能合并，跳过
+	var diags []diagnostic
+	for _, name := range names {
+		var layers []string
+		merge := false
+		for _, p := range layered[name] {
+			content, ok := contents[p]
+			if !ok || isMatrixFile(p) {
+				layers = nil
+				break
+			}
+			merge = merge || fragments[p] || hasMergeMarkers(content)
+			layers = append(layers, content)
+		}
+		if !merge || layers == nil {
+			continue
+		}
+		merged, err := mergeLayers(layers)
+		if err != nil {
+			diags = append(diags, diagnostic{File: label, Severity: severityError, Message: fmt.Sprintf("merge %s: %v", name, err)})
+			continue
+		}
+		for _, d := range validateConfigFile(name, []byte(merged), policy) {
+			// 行号对应合并后的内容，没有意义
+			diags = append(diags, diagnostic{File: label, Severity: d.Severity, Message: fmt.Sprintf("merged %s: %s", name, d.Message)})
+		}
+	}
+	return diags
+}
+
+// 可重复的命令行参数
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// validate 子命令：检查本地的配置仓库
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var repos, branches, registries stringList
+	fs.Var(&repos, "repo", "模拟的仓库 owner/name，可重复；也可写成 owner/name@branch")
+	fs.Var(&branches, "branch", "模拟的分支，可重复（默认 main）")
+	fs.Var(&registries, "allowed-registry", "允许的镜像仓库前缀，可重复")
+	targetsFile := fs.String("targets", "", "每行一个 owner/name[@branch] 的文件")
+	allowPrivileged := fs.Bool("allow-privileged", false, "允许 privileged: true")
+	denyLatest := fs.Bool("deny-latest", false, "禁止未固定版本或 :latest 的镜像")
+	strict := fs.Bool("strict", false, "warning 也视为失败")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: woodpecker-config-provider validate [flags] <dir>")
+		return 2
+	}
+
+	if *targetsFile != "" {
+		data, err := os.ReadFile(*targetsFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "read targets: %v\n", err)
+			return 2
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				repos = append(repos, line)
+			}
+		}
+	}
+	if len(branches) == 0 {
+		branches = stringList{"main"}
+	}
+
+	var targets []validateTarget
+	for _, repo := range repos {
+		if name, branch, ok := strings.Cut(repo, "@"); ok {
+			targets = append(targets, validateTarget{Repo: name, Branch: branch})
+			continue
+		}
+		for _, branch := range branches {
+			targets = append(targets, validateTarget{Repo: repo, Branch: branch})
+		}
+	}
+
+	policy := defaultValidatePolicy
+	policy.DenyPrivileged = !*allowPrivileged
+	policy.DenyLatestTag = *denyLatest
+	policy.AllowedRegistries = registries
+
+	diags, err := validateTree(fs.Arg(0), targets, policy)
+	if err != nil {
+		fmt.Fprintf(stderr, "validate: %v\n", err)
+		return 2
+	}
+
+	errorCount, warningCount := 0, 0
+	for _, d := range diags {
+		fmt.Fprintln(stdout, d)
+		if d.Severity == severityError {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+	fmt.Fprintf(stdout, "%d error(s), %d warning(s)\n", errorCount, warningCount)
+
+	if errorCount > 0 || (*strict && warningCount > 0) {
+		return 1
+	}
+	return 0
+}
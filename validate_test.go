@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		policy validatePolicy
+		want   []string
+	}{
+		{
+			name: "valid list steps",
+			yaml: "steps:\n  - name: build\n    image: golang:1.24\n    commands:\n      - go build\n",
+			want: nil,
+		},
+		{
+			name: "valid map steps",
+			yaml: "when:\n  - event: push\nsteps:\n  build:\n    image: golang:1.24\n",
+			want: nil,
+		},
+		{
+			name: "syntax error with line",
+			yaml: "steps:\n  - name: a\n    image: [\n",
+			want: []string{"x.yml:3: error: invalid YAML"},
+		},
+		{
+			name: "missing steps",
+			yaml: "when:\n  - event: push\n",
+			want: []string{`x.yml:1: error: missing required key "steps"`},
+		},
+		{
+			name: "missing image and duplicate name",
+			yaml: "steps:\n  - name: a\n    image: alpine\n  - name: a\n    commands: [ls]\n",
+			want: []string{
+				`x.yml:4: error: duplicate step name "a" (first defined on line 2)`,
+				`x.yml:4: error: step a: missing "image"`,
+			},
+		},
+		{
+			name: "unquoted colon in command",
+			yaml: "steps:\n  - name: a\n    image: alpine\n    commands:\n      - echo Repository: x\n",
+			want: []string{"x.yml:5: error: step a: command must be a string, got mapping"},
+		},
+		{
+			name:   "privileged denied",
+			yaml:   "steps:\n  - name: dind\n    image: docker:dind\n    privileged: true\n",
+			policy: validatePolicy{DenyPrivileged: true},
+			want:   []string{"x.yml:4: error: step dind: privileged mode is not allowed"},
+		},
+		{
+			name:   "latest tag denied",
+			yaml:   "steps:\n  - name: a\n    image: alpine\n  - name: b\n    image: registry.local:5000/tool\n  - name: c\n    image: alpine:3.20\n",
+			policy: validatePolicy{DenyLatestTag: true},
+			want: []string{
+				`x.yml:3: error: step a: image "alpine" must use a pinned tag`,
+				`x.yml:5: error: step b: image "registry.local:5000/tool" must use a pinned tag`,
+			},
+		},
+		{
+			name:   "registry allowlist",
+			yaml:   "steps:\n  - name: a\n    image: docker.io/alpine:3\n  - name: b\n    image: registry.local/alpine:3\n",
+			policy: validatePolicy{AllowedRegistries: []string{"registry.local"}},
+			want:   []string{`x.yml:3: error: step a: image "docker.io/alpine:3" is not from an allowed registry (registry.local)`},
+		},
+		{
+			name:   "unknown key warning",
+			yaml:   "stepz: []\nsteps:\n  - name: a\n    image: alpine\nx-anchors: {}\n",
+			policy: validatePolicy{WarnUnknownKeys: true},
+			want:   []string{`x.yml:1: warning: unknown top-level key "stepz"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := validateConfigFile("x.yml", []byte(tt.yaml), tt.policy)
+			if len(diags) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d: %v", len(diags), len(tt.want), diags)
+			}
+			for i, want := range tt.want {
+				if got := diags[i].String(); !strings.HasPrefix(got, want) {
+					t.Errorf("diagnostic[%d] = %q, want prefix %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestValidateCommand(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.yml":    "steps:\n  - name: build\n    image: golang:1.24\n",
+		"app/develop/build.yml": "steps:\n  - name: build\n",
+		"app/empty/README.md":   "nothing here",
+		".git/config.yml":       "not: [validated",
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := runCLI([]string{"validate",
+		"--repo", "team/app", "--branch", "main", "--branch", "develop",
+		"--repo", "team/app@empty", "--repo", "team/missing@main",
+		root}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1\n%s%s", code, stdout.String(), stderr.String())
+	}
+
+	out := stdout.String()
+	for _, want := range []string{
+		`app/develop/build.yml:2: error: step build: missing "image"`,
+		`team/app@empty: error: config path "app/empty" contains no pipeline files`,
+		`team/missing@main: error: config path "missing/main" does not exist`,
+		"3 error(s), 0 warning(s)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, ".git") {
+		t.Errorf("hidden directories should be skipped:\n%s", out)
+	}
+}
+
+func TestValidateCommandClean(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"app/main/build.yml": "steps:\n  - name: build\n    image: golang:1.24\n",
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"validate", "--repo", "team/app", root}, &stdout, &stderr); code != 0 {
+		t.Errorf("exit code = %d, want 0\n%s%s", code, stdout.String(), stderr.String())
+	}
+}